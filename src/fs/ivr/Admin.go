@@ -0,0 +1,184 @@
+// fs/ivr/ Admin
+
+package ivr
+
+import (
+	l4g "code.google.com/p/log4go"
+	"encoding/json"
+	"fmt"
+	"fs/ivr/calllog"
+	"net/http"
+	"time"
+)
+
+// CallInfo is the admin view of an active call.
+type CallInfo struct {
+	ChannelName string
+	ChannelId   string
+	Flow        string
+	ANI         string
+	DNIS        string
+	ActiveNode  string
+	DtmfValue   string // Masked when sensitive.
+	State       string
+	CreateTime  time.Time
+}
+
+// InitAdminServer serves the admin api :
+//
+//	GET    /calls                        active calls
+//	GET    /metrics                      persistence queue metrics
+//	GET    /loglevel                     log level overrides
+//	POST   /loglevel?key=ANI|uuid&level= set a per call log level
+//	DELETE /loglevel?key=ANI|uuid        clear a per call log level
+//	GET    /schedule                     schedule overrides
+//	POST   /schedule?name=node|*         force a ScheduleNode (* for all) closed
+//	DELETE /schedule?name=node|*         back to the business hours
+func InitAdminServer(port int) {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/calls", handleAdminCalls)
+	mux.HandleFunc("/loglevel", handleAdminLogLevel)
+	mux.HandleFunc("/metrics", handleAdminMetrics)
+	mux.HandleFunc("/schedule", handleAdminSchedule)
+
+	l4g.Info("Admin server listening TCP :%d", port)
+	err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
+	if err != nil {
+		l4g.Error("Admin server failure for %s", err.Error())
+	}
+}
+
+func (ivr *IVR) Calls() []CallInfo {
+
+	ivr.channelMutex.Lock()
+	defer ivr.channelMutex.Unlock()
+
+	calls := make([]CallInfo, 0, len(ivr.channelMap))
+	for _, ivrChannel := range ivr.channelMap {
+		calls = append(calls, ivrChannel.callInfo())
+	}
+	return calls
+}
+
+// callInfo snapshots the channel,its state is written by the call and the
+// event goroutines while the admin api reads it.
+func (ivrChannel *IVRChannel) callInfo() CallInfo {
+
+	ivrChannel.stateMutex.RLock()
+	defer ivrChannel.stateMutex.RUnlock()
+
+	dtmfValue := ivrChannel.DtmfValue
+	if ivrChannel.DtmfSensitive {
+		dtmfValue = calllog.Mask(dtmfValue)
+	}
+	return CallInfo{
+		ChannelName: ivrChannel.ChannelName,
+		ChannelId:   ivrChannel.ChannelId,
+		Flow:        ivrChannel.Flow,
+		ANI:         ivrChannel.Log.Get(calllog.Field_ANI),
+		DNIS:        ivrChannel.Log.Get(calllog.Field_DNIS),
+		ActiveNode:  ivrChannel.ActiveNode,
+		DtmfValue:   dtmfValue,
+		State:       ivrChannel.ChannelState,
+		CreateTime:  ivrChannel.ChanCreateTime,
+	}
+}
+
+func handleAdminCalls(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeAdminJson(w, ivr.Calls())
+}
+
+func handleAdminMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := make(map[string]interface{})
+	metrics["Calls"] = ivr.limiter.Calls("")
+	if statsPersistor, ok := ivr.persistor.(interface {
+		Stats() PersistStats
+	}); ok {
+		metrics["Persistence"] = statsPersistor.Stats()
+	}
+	writeAdminJson(w, metrics)
+}
+
+func handleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+
+	key := r.FormValue("key")
+
+	switch r.Method {
+	case "GET":
+	case "POST", "PUT":
+		level, ok := calllog.ParseLevel(r.FormValue("level"))
+		if key == "" || !ok {
+			http.Error(w, "key and level are required", http.StatusBadRequest)
+			return
+		}
+		calllog.SetOverride(key, level)
+		l4g.Info("Admin set log level %s for %s", level, key)
+	case "DELETE":
+		if key == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+		calllog.ClearOverride(key)
+		l4g.Info("Admin clear log level for %s", key)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	levels := make(map[string]string)
+	for k, v := range calllog.Overrides() {
+		levels[k] = v.String()
+	}
+	writeAdminJson(w, levels)
+}
+
+func handleAdminSchedule(w http.ResponseWriter, r *http.Request) {
+
+	name := r.FormValue("name")
+
+	switch r.Method {
+	case "GET":
+	case "POST", "PUT":
+		state := r.FormValue("state")
+		if state == "" {
+			state = Schedule_State_Closed
+		}
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		if name != Schedule_All && !isScheduleName(name) {
+			http.Error(w, "No ScheduleNode find : "+name, http.StatusNotFound)
+			return
+		}
+		if err := SetScheduleOverride(name, state); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		l4g.Warn("Admin force schedule %s %s", name, state)
+	case "DELETE":
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		ClearScheduleOverride(name)
+		l4g.Info("Admin clear schedule override for %s", name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeAdminJson(w, ScheduleOverrides())
+}
+
+func writeAdminJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		l4g.Warn("Write admin response failure for %s", err.Error())
+	}
+}
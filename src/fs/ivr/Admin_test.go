@@ -0,0 +1,46 @@
+// Admin test
+
+package ivr
+
+import (
+	"fs/ivr/calllog"
+	"sync"
+	"testing"
+)
+
+func TestCallsSnapshot(t *testing.T) {
+
+	server := &IVR{channelMap: make(map[string]*IVRChannel)}
+	ivrChannel := &IVRChannel{ChannelName: "127.0.0.1:5000", Vars: NewCallVars(), Log: calllog.New()}
+	server.addChannel(ivrChannel)
+
+	// The call and the event goroutines write while the admin api polls.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			ivrChannel.SetActiveNode("pwdService")
+			ivrChannel.setDtmfValue("123456", true)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			ivrChannel.setChannelId("call-1")
+			ivrChannel.setChannelState(IVRChannel_State_Service)
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		server.Calls()
+	}
+	wg.Wait()
+
+	calls := server.Calls()
+	if len(calls) != 1 || calls[0].ChannelId != "call-1" || calls[0].ActiveNode != "pwdService" ||
+		calls[0].State != IVRChannel_State_Service || calls[0].DtmfValue == "123456" {
+		t.Fatalf("Calls=%+v", calls)
+	}
+
+	t.Log("Test pass.")
+}
@@ -0,0 +1,350 @@
+// fs/ivr/ AsyncPersistor
+
+package ivr
+
+import (
+	"bufio"
+	l4g "code.google.com/p/log4go"
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RecordWriter is the storage behind AsyncPersistor.
+type RecordWriter interface {
+	Open() error
+	WriteRecords(records []*CallRecord) error
+	Close()
+}
+
+type PersistStats struct {
+	QueueDepth int
+	QueueSize  int
+	Queued     int64
+	Written    int64
+	Dropped    int64 // Queue full.
+	Spilled    int64 // Written to the spill file.
+	Replayed   int64 // Read back from the spill file.
+	Rejected   int64 // Refused by the writer for good,written to the reject file.
+	Retries    int64
+}
+
+// permanentError is implemented by errors of records the writer will never
+// accept,eg. a duplicate CallId or a too long value.
+type permanentError interface {
+	Permanent() bool
+}
+
+// AsyncPersistor queues call records and writes them in batches from its own
+// goroutine,so a slow database never delays a call.When the writer keeps
+// failing the records go to a spill file which is replayed once it recovers.
+type AsyncPersistor struct {
+	writer    RecordWriter
+	config    Persistence
+	queue     chan *CallRecord
+	done      chan bool
+	closeOnce sync.Once
+	closed    bool
+	mutex     sync.RWMutex // Guards closed and the send to queue.
+	spilled   bool         // Spill file may hold records,only used by the writer goroutine.
+	stats     PersistStats
+}
+
+func NewAsyncPersistor(writer RecordWriter, config Persistence) *AsyncPersistor {
+	persistor := new(AsyncPersistor)
+	persistor.writer = writer
+	persistor.config = config.withDefaults()
+	persistor.queue = make(chan *CallRecord, persistor.config.QueueSize)
+	persistor.done = make(chan bool)
+	persistor.stats.QueueSize = persistor.config.QueueSize
+	return persistor
+}
+
+// Open opens the writer and starts the writer goroutine.A writer which fails
+// to open is not fatal,records are retried and spilled until it comes back.
+func (persistor *AsyncPersistor) Open() error {
+
+	err := persistor.writer.Open()
+	if err != nil {
+		l4g.Error("Open record writer failure for %s,records will be spilled to %s", err.Error(), persistor.config.SpillFile)
+	}
+
+	if info, statErr := os.Stat(persistor.config.SpillFile); statErr == nil && info.Size() > 0 {
+		persistor.spilled = true
+	}
+
+	go persistor.writeLoop()
+	return err
+}
+
+func (persistor *AsyncPersistor) Persist(ivrChannel *IVRChannel) {
+
+	record := ivrChannel.finishRecord()
+
+	persistor.mutex.RLock()
+	defer persistor.mutex.RUnlock()
+	if persistor.closed {
+		atomic.AddInt64(&persistor.stats.Dropped, 1)
+		ivrChannel.Log.Error("Persistor is closed,drop call record %s", record.CallId)
+		return
+	}
+	select {
+	case persistor.queue <- record:
+		atomic.AddInt64(&persistor.stats.Queued, 1)
+	default:
+		atomic.AddInt64(&persistor.stats.Dropped, 1)
+		ivrChannel.Log.Error("Persist queue is full,drop call record %s", record.CallId)
+	}
+}
+
+// Close flushes the queued records and closes the writer,records persisted
+// after Close are dropped.
+func (persistor *AsyncPersistor) Close() {
+	persistor.closeOnce.Do(func() {
+		persistor.mutex.Lock()
+		persistor.closed = true
+		close(persistor.queue)
+		persistor.mutex.Unlock()
+		<-persistor.done
+		persistor.writer.Close()
+	})
+}
+
+func (persistor *AsyncPersistor) Stats() PersistStats {
+	return PersistStats{
+		QueueDepth: len(persistor.queue),
+		QueueSize:  persistor.stats.QueueSize,
+		Queued:     atomic.LoadInt64(&persistor.stats.Queued),
+		Written:    atomic.LoadInt64(&persistor.stats.Written),
+		Dropped:    atomic.LoadInt64(&persistor.stats.Dropped),
+		Spilled:    atomic.LoadInt64(&persistor.stats.Spilled),
+		Replayed:   atomic.LoadInt64(&persistor.stats.Replayed),
+		Rejected:   atomic.LoadInt64(&persistor.stats.Rejected),
+		Retries:    atomic.LoadInt64(&persistor.stats.Retries),
+	}
+}
+
+func (persistor *AsyncPersistor) writeLoop() {
+
+	defer close(persistor.done)
+
+	flushInterval := time.Duration(persistor.config.FlushInterval) * time.Millisecond
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*CallRecord, 0, persistor.config.BatchSize)
+	for {
+		select {
+		case record, ok := <-persistor.queue:
+			if !ok {
+				persistor.flush(batch)
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= persistor.config.BatchSize {
+				persistor.flush(batch)
+				batch = make([]*CallRecord, 0, persistor.config.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				persistor.flush(batch)
+				batch = make([]*CallRecord, 0, persistor.config.BatchSize)
+			} else if persistor.spilled {
+				persistor.replaySpill()
+			}
+		}
+	}
+}
+
+func (persistor *AsyncPersistor) flush(batch []*CallRecord) {
+
+	if len(batch) == 0 {
+		return
+	}
+
+	// Keep the order,records already spilled go first.
+	if persistor.spilled {
+		persistor.spill(batch)
+		persistor.replaySpill()
+		return
+	}
+
+	if left, err := persistor.writeWithRetry(batch); err != nil {
+		l4g.Error("Write %d call records failure for %s,spill them to %s", len(left), err.Error(), persistor.config.SpillFile)
+		persistor.spill(left)
+	}
+}
+
+// writeWithRetry retries a failed batch with exponential backoff,it returns
+// the records left unwritten.
+func (persistor *AsyncPersistor) writeWithRetry(batch []*CallRecord) ([]*CallRecord, error) {
+
+	backoff := time.Duration(persistor.config.RetryBackoff) * time.Millisecond
+	var err error
+	for attempt := 0; attempt <= persistor.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			atomic.AddInt64(&persistor.stats.Retries, 1)
+			time.Sleep(backoff)
+			backoff = backoff * 2
+		}
+		if batch, _, err = persistor.writeRecords(batch); err == nil {
+			return nil, nil
+		}
+		l4g.Warn("Write call records attempt %d failure for %s", attempt+1, err.Error())
+	}
+	return batch, err
+}
+
+// writeRecords writes batch,a batch refused for good is written record by
+// record and the refused records go to the reject file,so one bad record
+// cannot hold back the others.It returns the records left on a failure
+// worth retrying and the number of records rejected.
+func (persistor *AsyncPersistor) writeRecords(batch []*CallRecord) ([]*CallRecord, int, error) {
+
+	err := persistor.writer.WriteRecords(batch)
+	if err == nil {
+		atomic.AddInt64(&persistor.stats.Written, int64(len(batch)))
+		return nil, 0, nil
+	}
+	if !isPermanentError(err) {
+		return batch, 0, err
+	}
+	if len(batch) == 1 {
+		persistor.reject(batch[0], err)
+		return nil, 1, nil
+	}
+
+	rejected := 0
+	for i, record := range batch {
+		if err = persistor.writer.WriteRecords(batch[i : i+1]); err == nil {
+			atomic.AddInt64(&persistor.stats.Written, 1)
+			continue
+		}
+		if !isPermanentError(err) {
+			return batch[i:], rejected, err
+		}
+		persistor.reject(record, err)
+		rejected++
+	}
+	return nil, rejected, nil
+}
+
+// isPermanentError tells a record refused by the writer from a failure of
+// the writer itself.
+func isPermanentError(err error) bool {
+	if permanent, ok := err.(permanentError); ok {
+		return permanent.Permanent()
+	}
+	return isConstraintError(err)
+}
+
+func (persistor *AsyncPersistor) reject(record *CallRecord, cause error) {
+
+	atomic.AddInt64(&persistor.stats.Rejected, 1)
+	l4g.Error("Call record %s is rejected for %s,move it to %s", record.CallId, cause.Error(), persistor.config.RejectFile)
+
+	file, err := os.OpenFile(persistor.config.RejectFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		l4g.Error("Open reject file failure for %s,drop call record %s", err.Error(), record.CallId)
+		return
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(record); err != nil {
+		l4g.Error("Reject call record %s failure for %s", record.CallId, err.Error())
+	}
+}
+
+func (persistor *AsyncPersistor) spill(batch []*CallRecord) {
+
+	file, err := os.OpenFile(persistor.config.SpillFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		atomic.AddInt64(&persistor.stats.Dropped, int64(len(batch)))
+		l4g.Error("Open spill file failure for %s,drop %d call records", err.Error(), len(batch))
+		return
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, record := range batch {
+		if err := encoder.Encode(record); err != nil {
+			atomic.AddInt64(&persistor.stats.Dropped, 1)
+			l4g.Error("Spill call record %s failure for %s", record.CallId, err.Error())
+			continue
+		}
+		atomic.AddInt64(&persistor.stats.Spilled, 1)
+	}
+	persistor.spilled = true
+}
+
+// replaySpill writes the spilled records back,the file keeps what is left on failure.
+func (persistor *AsyncPersistor) replaySpill() {
+
+	records, err := readRecordFile(persistor.config.SpillFile)
+	if err != nil {
+		l4g.Error("Read spill file failure for %s", err.Error())
+		return
+	}
+
+	// Rejected records are done too,but they are not replayed.
+	written, replayed := 0, 0
+	for written < len(records) {
+		end := written + persistor.config.BatchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		left, rejected, err := persistor.writeRecords(records[written:end])
+		done := end - written - len(left)
+		atomic.AddInt64(&persistor.stats.Replayed, int64(done-rejected))
+		written = written + done
+		replayed = replayed + done - rejected
+		if err != nil {
+			l4g.Warn("Replay spill file failure for %s,%d records left", err.Error(), len(records)-written)
+			break
+		}
+	}
+
+	if written == 0 {
+		return
+	}
+
+	if err := os.Remove(persistor.config.SpillFile); err != nil {
+		l4g.Error("Remove spill file failure for %s", err.Error())
+		return
+	}
+	persistor.spilled = false
+	if written < len(records) {
+		persistor.spill(records[written:])
+	}
+	l4g.Info("Replay %d call records from spill file", replayed)
+}
+
+func readRecordFile(name string) ([]*CallRecord, error) {
+
+	file, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make([]*CallRecord, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := new(CallRecord)
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			l4g.Warn("Skip bad spilled record for %s", err.Error())
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
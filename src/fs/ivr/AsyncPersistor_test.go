@@ -0,0 +1,140 @@
+// AsyncPersistor test
+
+package ivr
+
+import (
+	"errors"
+	"fs/ivr/calllog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memWriter struct {
+	mutex   sync.Mutex
+	down    bool
+	bad     string // CallId refused for good.
+	records []*CallRecord
+}
+
+type rejectErr struct {
+	callId string
+}
+
+func (err rejectErr) Error() string   { return "duplicate entry " + err.callId }
+func (err rejectErr) Permanent() bool { return true }
+
+func (writer *memWriter) Open() error { return nil }
+func (writer *memWriter) Close()      {}
+
+func (writer *memWriter) WriteRecords(records []*CallRecord) error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.down {
+		return errors.New("database is down")
+	}
+	for _, record := range records {
+		if record.CallId == writer.bad {
+			return rejectErr{record.CallId}
+		}
+	}
+	writer.records = append(writer.records, records...)
+	return nil
+}
+
+func newTestChannel(callId string) *IVRChannel {
+	ivrChannel := new(IVRChannel)
+	ivrChannel.ChannelId = callId
+	ivrChannel.Vars = NewCallVars()
+	ivrChannel.Vars.Set("callId", callId)
+	ivrChannel.Record = NewCallRecord(time.Now())
+	ivrChannel.Log = calllog.New()
+	return ivrChannel
+}
+
+func TestAsyncPersistorSpill(t *testing.T) {
+
+	spillFile := filepath.Join(t.TempDir(), "cdr.spill")
+	writer := &memWriter{down: true}
+	persistor := NewAsyncPersistor(writer, Persistence{BatchSize: 2, FlushInterval: 10, MaxRetries: 1, RetryBackoff: 1, SpillFile: spillFile})
+	persistor.Open()
+
+	persistor.Persist(newTestChannel("call-1"))
+	persistor.Persist(newTestChannel("call-2"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for persistor.Stats().Spilled < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if persistor.Stats().Spilled != 2 {
+		t.Fatalf("Spilled=%d,expect 2", persistor.Stats().Spilled)
+	}
+
+	writer.mutex.Lock()
+	writer.down = false
+	writer.mutex.Unlock()
+
+	persistor.Persist(newTestChannel("call-3"))
+	persistor.Close()
+
+	if len(writer.records) != 3 || writer.records[0].CallId != "call-1" || writer.records[2].CallId != "call-3" {
+		t.Fatalf("Written records=%d,expect call-1..call-3 in order", len(writer.records))
+	}
+	if _, err := os.Stat(spillFile); !os.IsNotExist(err) {
+		t.Fatalf("Spill file is not removed after replay")
+	}
+	if writer.records[0].Outcome != Outcome_Abandoned {
+		t.Fatalf("Outcome=%s,expect %s", writer.records[0].Outcome, Outcome_Abandoned)
+	}
+	t.Log("Test pass.")
+}
+
+func TestAsyncPersistorReject(t *testing.T) {
+
+	dir := t.TempDir()
+	spillFile := filepath.Join(dir, "cdr.spill")
+	rejectFile := filepath.Join(dir, "cdr.rejected")
+	writer := &memWriter{down: true, bad: "call-2"}
+	// The ticker never fires,the full batch spills and Close flushes call-4.
+	persistor := NewAsyncPersistor(writer, Persistence{BatchSize: 3, FlushInterval: 3600000, MaxRetries: 0, RetryBackoff: 1,
+		SpillFile: spillFile, RejectFile: rejectFile})
+	persistor.Open()
+
+	for _, callId := range []string{"call-1", "call-2", "call-3"} {
+		persistor.Persist(newTestChannel(callId))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for persistor.Stats().Spilled < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The bad record is rejected during the replay and the others go on.
+	writer.mutex.Lock()
+	writer.down = false
+	writer.mutex.Unlock()
+	persistor.Persist(newTestChannel("call-4"))
+	persistor.Close()
+
+	if len(writer.records) != 3 || writer.records[0].CallId != "call-1" || writer.records[1].CallId != "call-3" || writer.records[2].CallId != "call-4" {
+		t.Fatalf("Written records=%d,expect call-1,call-3,call-4", len(writer.records))
+	}
+	if stats := persistor.Stats(); stats.Rejected != 1 || stats.Replayed != 3 {
+		t.Fatalf("Rejected=%d,Replayed=%d,expect 1,3", stats.Rejected, stats.Replayed)
+	}
+	rejected, err := readRecordFile(rejectFile)
+	if err != nil || len(rejected) != 1 || rejected[0].CallId != "call-2" {
+		t.Fatalf("Reject file=%d records,err=%v,expect call-2", len(rejected), err)
+	}
+	if _, err := os.Stat(spillFile); !os.IsNotExist(err) {
+		t.Fatalf("Spill file is not removed after replay")
+	}
+
+	// Persist after Close drops the record.
+	persistor.Persist(newTestChannel("call-5"))
+	if persistor.Stats().Dropped != 1 {
+		t.Fatalf("Dropped=%d,expect 1", persistor.Stats().Dropped)
+	}
+	t.Log("Test pass.")
+}
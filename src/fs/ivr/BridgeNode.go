@@ -0,0 +1,136 @@
+// fs/ivr/ BridgeNode
+
+package ivr
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const Default_Ring_Timeout int = 30
+
+const Bridge_Mode_Sequential string = "sequential"
+const Bridge_Mode_Simultaneous string = "simultaneous"
+
+const Bridge_Result_Answered string = "answered"
+const Bridge_Result_Busy string = "busy"
+const Bridge_Result_NoAnswer string = "noanswer"
+const Bridge_Result_Failed string = "failed"
+
+type BridgeEndpoints struct {
+	Mode     string   `xml:"mode,attr"` // sequential or simultaneous,default sequential.
+	Endpoint []string // Dial strings,eg. user/1000 or sofia/gateway/gw1/13800138000.
+}
+
+// BridgeNode bridges the caller to the endpoints while it stays in the flow.
+// The caller hears Ringback (or MusicOnHold) until an endpoint answers or
+// RingTimeout seconds pass,the branch of the result is executed when the
+// bridge ends and the caller is still on line.
+type BridgeNode struct {
+	NodeName    string `xml:"name,attr"`
+	Prompts     PromptEntity
+	Endpoints   BridgeEndpoints
+	Ringback    string // eg. %(2000,4000,440,480) or ${us-ring}.
+	MusicOnHold string // eg. local_stream://moh,used when Ringback is empty.
+	RingTimeout int    // Second.
+	Answered    string
+	Busy        string
+	NoAnswer    string
+	Failed      string
+}
+
+func (node BridgeNode) dialString(vars *CallVars) string {
+	ringTimeout := node.RingTimeout
+	if ringTimeout <= 0 {
+		ringTimeout = Default_Ring_Timeout
+	}
+
+	separator := "|"
+	if node.Endpoints.Mode == Bridge_Mode_Simultaneous {
+		separator = ","
+	}
+
+	legs := make([]string, 0, len(node.Endpoints.Endpoint))
+	for _, endpoint := range node.Endpoints.Endpoint {
+		legs = append(legs, "[leg_timeout="+strconv.Itoa(ringTimeout)+"]"+strings.TrimSpace(vars.Expand(endpoint)))
+	}
+	return strings.Join(legs, separator)
+}
+
+// bridgeResult maps originate_disposition of the bridge to a result.
+func bridgeResult(bridged bool, disposition string) string {
+	if bridged {
+		return Bridge_Result_Answered
+	}
+	switch disposition {
+	case "SUCCESS", "ANSWER":
+		return Bridge_Result_Answered
+	case "USER_BUSY":
+		return Bridge_Result_Busy
+	case "NO_ANSWER", "NO_USER_RESPONSE", "ALLOTTED_TIMEOUT", "PROGRESS_TIMEOUT":
+		return Bridge_Result_NoAnswer
+	}
+	return Bridge_Result_Failed
+}
+
+func (node BridgeNode) branch(result string) string {
+	switch result {
+	case Bridge_Result_Answered:
+		return node.Answered
+	case Bridge_Result_Busy:
+		return node.Busy
+	case Bridge_Result_NoAnswer:
+		return node.NoAnswer
+	}
+	return node.Failed
+}
+
+func (node BridgeNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	executePrompt(node.Prompts.Prompt, ivrChannel)
+
+	ringback := node.Ringback
+	if ringback == "" {
+		ringback = node.MusicOnHold
+	}
+	if ringback != "" {
+		// ringback before the caller is answered,transfer_ringback after.
+		ivrChannel.Esocket.SetVar("ringback", ringback)
+		ivrChannel.Esocket.SetVar("transfer_ringback", ringback)
+	}
+	ivrChannel.Esocket.SetVar("hangup_after_bridge", "false")
+	ivrChannel.Esocket.SetVar("continue_on_fail", "true")
+
+	dialString := node.dialString(ivrChannel.Vars)
+	ivrChannel.setBridged(false)
+	ivrChannel.Log.Info("Bridge call to %s", dialString)
+	event, err := ivrChannel.executeWait("bridge", dialString, 0)
+	if err != nil {
+		if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+			if ivrChannel.isBridged() {
+				ivrChannel.Record.SetOutcome(Outcome_Transferred)
+			}
+			return "", err
+		}
+		ivrChannel.Log.Warn("Bridge failure for %s", err.Error())
+		return node.Failed, nil
+	}
+
+	ivrChannel.channelVars(event, "originate_disposition")
+	disposition := event.Header["variable_originate_disposition"]
+	result := bridgeResult(ivrChannel.isBridged(), disposition)
+	ivrChannel.Vars.Set("bridge_result", result)
+	if result == Bridge_Result_Answered {
+		ivrChannel.Record.SetOutcome(Outcome_Transferred)
+	}
+	ivrChannel.Log.Info("Bridge ended result=%s,disposition=%s", result, disposition)
+
+	return node.branch(result), nil
+}
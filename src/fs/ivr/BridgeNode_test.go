@@ -0,0 +1,162 @@
+// BridgeNode test
+
+package ivr
+
+import (
+	"fs/ivr/calllog"
+	"fs/ivr/eventsocket"
+	"testing"
+	"time"
+)
+
+func TestBridgeNode(t *testing.T) {
+
+	vars := NewCallVars()
+	vars.Set("manager", "1002")
+
+	node := BridgeNode{NodeName: "vipService", Endpoints: BridgeEndpoints{Endpoint: []string{"user/1001", " user/${manager} "}}}
+	tests := []struct {
+		mode        string
+		ringTimeout int
+		dialString  string
+	}{
+		{"", 0, "[leg_timeout=30]user/1001|[leg_timeout=30]user/1002"},
+		{Bridge_Mode_Sequential, 20, "[leg_timeout=20]user/1001|[leg_timeout=20]user/1002"},
+		{Bridge_Mode_Simultaneous, 15, "[leg_timeout=15]user/1001,[leg_timeout=15]user/1002"},
+	}
+	for _, test := range tests {
+		node.Endpoints.Mode, node.RingTimeout = test.mode, test.ringTimeout
+		if dialString := node.dialString(vars); dialString != test.dialString {
+			t.Fatalf("dialString(%s,%d)=%s,expect %s", test.mode, test.ringTimeout, dialString, test.dialString)
+		}
+	}
+
+	results := []struct {
+		bridged     bool
+		disposition string
+		result      string
+	}{
+		{true, "", Bridge_Result_Answered},
+		{true, "USER_BUSY", Bridge_Result_Answered},
+		{false, "SUCCESS", Bridge_Result_Answered},
+		{false, "USER_BUSY", Bridge_Result_Busy},
+		{false, "NO_ANSWER", Bridge_Result_NoAnswer},
+		{false, "ALLOTTED_TIMEOUT", Bridge_Result_NoAnswer},
+		{false, "CALL_REJECTED", Bridge_Result_Failed},
+		{false, "", Bridge_Result_Failed},
+	}
+	for _, test := range results {
+		if result := bridgeResult(test.bridged, test.disposition); result != test.result {
+			t.Fatalf("bridgeResult(%v,%s)=%s,expect %s", test.bridged, test.disposition, result, test.result)
+		}
+	}
+
+	node = BridgeNode{Answered: "exit", Busy: "agentService", NoAnswer: "voiceMail", Failed: "toAgent"}
+	if node.branch(Bridge_Result_NoAnswer) != "voiceMail" || node.branch("unknown") != "toAgent" {
+		t.Fatalf("branch noanswer=%s,unknown=%s", node.branch(Bridge_Result_NoAnswer), node.branch("unknown"))
+	}
+
+	t.Log("Test pass.")
+}
+
+func TestExecuteWaitTimeout(t *testing.T) {
+
+	ivrChannel := &IVRChannel{Vars: NewCallVars(), Log: calllog.New(), AppDone: make(chan *eventsocket.Event, 1)}
+	closeSwitch := fakeSwitch(ivrChannel, func(cmd string) {})
+	defer closeSwitch()
+
+	if _, err := ivrChannel.executeWait("bridge", "user/1001", 50); err == nil {
+		t.Fatal("executeWait without completion,expect timeout")
+	}
+	if ivrChannel.executing() {
+		t.Fatal("executeWait timeout leaves the application waited")
+	}
+
+	// The late completion of the bridge must not end the next wait.
+	ivrChannel.onExecuteComplete(&eventsocket.Event{Header: eventsocket.EventHeader{"Application": "bridge"}})
+	if len(ivrChannel.AppDone) != 0 {
+		t.Fatal("Late execute complete is matched")
+	}
+
+	t.Log("Test pass.")
+}
+
+func TestOnEventOtherLeg(t *testing.T) {
+
+	ivrChannel := &IVRChannel{Vars: NewCallVars(), Log: calllog.New(), ChannelId: "a-leg", Record: NewCallRecord(time.Now()),
+		Dtmf: make(chan string, 1), PlaybackDone: make(chan bool, 1), AppDone: make(chan *eventsocket.Event, 1)}
+	ivrChannel.Vars.Set("ANI", "13800138000")
+
+	event := func(leg, name string, headers ...string) *eventsocket.Event {
+		header := eventsocket.EventHeader{"Event-Name": name, "Unique-ID": leg, "Channel-Call-UUID": "a-leg"}
+		for i := 0; i+1 < len(headers); i += 2 {
+			header[headers[i]] = headers[i+1]
+		}
+		return &eventsocket.Event{Header: header}
+	}
+
+	done := make(chan bool)
+	go func() {
+		// The agent leg of the bridge.
+		ivrChannel.OnEvent(event("b-leg", "CHANNEL_ANSWER", "Caller-Orig-Caller-ID-Number", "1001"))
+		ivrChannel.OnEvent(event("b-leg", "DTMF", "DTMF-Digit", "5"))
+		ivrChannel.OnEvent(event("b-leg", "CHANNEL_HANGUP", "Hangup-Cause", "NORMAL_CLEARING"))
+		// Nobody waits for these,they must not block the receive loop.
+		for _, dtmf := range []string{"1", "2", "3"} {
+			ivrChannel.OnEvent(event("a-leg", "DTMF", "DTMF-Digit", dtmf))
+		}
+		ivrChannel.OnEvent(event("a-leg", "PLAYBACK_STOP"))
+		ivrChannel.OnEvent(event("a-leg", "PLAYBACK_STOP"))
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("OnEvent blocks")
+	}
+
+	if ani := ivrChannel.Vars.Get("ANI"); ani != "13800138000" {
+		t.Fatalf("ANI=%s,expect 13800138000", ani)
+	}
+	if cause := ivrChannel.Record.Cause(); cause != "" {
+		t.Fatalf("Hangup cause=%s of the agent leg", cause)
+	}
+	if dtmf := <-ivrChannel.Dtmf; dtmf != "1" {
+		t.Fatalf("Dtmf=%s,expect 1", dtmf)
+	}
+
+	t.Log("Test pass.")
+}
+
+func TestChannelVarsNotVerbose(t *testing.T) {
+
+	ivrChannel := &IVRChannel{Vars: NewCallVars(), Log: calllog.New(), ChannelId: "a-leg"}
+	closeSwitch := fakeApiSwitch(ivrChannel, func(cmd string) string {
+		switch cmd {
+		case "uuid_getvar a-leg originate_disposition":
+			return "USER_BUSY"
+		}
+		return "_undef_"
+	}, func(cmd string) {})
+	defer closeSwitch()
+
+	// CHANNEL_EXECUTE_COMPLETE without verbose_events has no variable_ headers.
+	event := &eventsocket.Event{Header: eventsocket.EventHeader{"Event-Name": "CHANNEL_EXECUTE_COMPLETE", "Application": "bridge"}}
+	ivrChannel.channelVars(event, "originate_disposition", "playback_terminator_used")
+	if result := bridgeResult(false, event.Header["variable_originate_disposition"]); result != Bridge_Result_Busy {
+		t.Fatalf("bridgeResult=%s,expect busy", result)
+	}
+	if _, ok := event.Header["variable_playback_terminator_used"]; ok {
+		t.Fatal("Undefined playback_terminator_used is set")
+	}
+
+	// A verbose event is not completed.
+	event = &eventsocket.Event{Header: eventsocket.EventHeader{"variable_uuid": "a-leg"}}
+	ivrChannel.channelVars(event, "originate_disposition")
+	if _, ok := event.Header["variable_originate_disposition"]; ok {
+		t.Fatal("Verbose event is completed by uuid_getvar")
+	}
+
+	t.Log("Test pass.")
+}
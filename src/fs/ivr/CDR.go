@@ -0,0 +1,197 @@
+// fs/ivr/ CDR
+
+package ivr
+
+import (
+	"fs/ivr/calllog"
+	"sync"
+	"time"
+)
+
+const Outcome_SelfServed string = "self-served"
+const Outcome_Transferred string = "transferred"
+const Outcome_Abandoned string = "abandoned"
+
+const Input_Match string = "match"
+const Input_NoMatch string = "nomatch"
+const Input_NoInput string = "noinput"
+
+type NodeVisit struct {
+	Node      string
+	EnterTime time.Time
+	LeaveTime time.Time
+	Result    string // Next node or error.
+}
+
+type CollectedInput struct {
+	Node      string
+	Value     string // Masked when sensitive.
+	Sensitive bool
+	Result    string // match,nomatch or noinput.
+	InputTime time.Time
+}
+
+// Recording is a message recorded by a RecordNode.
+type Recording struct {
+	Node       string
+	Path       string
+	Duration   int    // Millisecond.
+	Reason     string // terminator,silence,maxduration or hangup.
+	RecordTime time.Time
+}
+
+// CallRecord is the IVR call detail record written when the call ends.
+type CallRecord struct {
+	CallId       string
+	ChannelId    string
+	Flow         string
+	ANI          string
+	DNIS         string
+	StartTime    time.Time
+	AnswerTime   time.Time
+	EndTime      time.Time
+	HangupCause  string
+	Path         []NodeVisit
+	Inputs       []CollectedInput
+	Recordings   []Recording
+	NoInputTimes int
+	NoMatchTimes int
+	Outcome      string
+	mutex        sync.Mutex
+}
+
+func NewCallRecord(startTime time.Time) *CallRecord {
+	record := new(CallRecord)
+	record.StartTime = startTime
+	record.Path = make([]NodeVisit, 0)
+	record.Inputs = make([]CollectedInput, 0)
+	record.Recordings = make([]Recording, 0)
+	return record
+}
+
+func (record *CallRecord) Answer(answerTime time.Time) {
+	record.mutex.Lock()
+	defer record.mutex.Unlock()
+	if record.AnswerTime.IsZero() {
+		record.AnswerTime = answerTime
+	}
+}
+
+func (record *CallRecord) Hangup(cause string) {
+	record.mutex.Lock()
+	defer record.mutex.Unlock()
+	if record.HangupCause == "" {
+		record.HangupCause = cause
+	}
+}
+
+func (record *CallRecord) Cause() string {
+	record.mutex.Lock()
+	defer record.mutex.Unlock()
+	return record.HangupCause
+}
+
+// SetOutcome keeps the first outcome,eg. a transferred call stays transferred
+// when the caller hangs up later.
+func (record *CallRecord) SetOutcome(outcome string) {
+	record.mutex.Lock()
+	defer record.mutex.Unlock()
+	if record.Outcome == "" {
+		record.Outcome = outcome
+	}
+}
+
+func (record *CallRecord) EnterNode(node string) {
+	record.mutex.Lock()
+	defer record.mutex.Unlock()
+	record.Path = append(record.Path, NodeVisit{Node: node, EnterTime: time.Now()})
+}
+
+func (record *CallRecord) LeaveNode(result string) {
+	record.mutex.Lock()
+	defer record.mutex.Unlock()
+	if n := len(record.Path); n > 0 && record.Path[n-1].LeaveTime.IsZero() {
+		record.Path[n-1].LeaveTime = time.Now()
+		record.Path[n-1].Result = result
+	}
+}
+
+func (record *CallRecord) AddInput(input CollectedInput) {
+	record.mutex.Lock()
+	defer record.mutex.Unlock()
+	input.InputTime = time.Now()
+	record.Inputs = append(record.Inputs, input)
+}
+
+// AddRecording adds recording to the record,a re-recorded message of the same
+// path replaces the previous one.
+func (record *CallRecord) AddRecording(recording Recording) {
+	record.mutex.Lock()
+	defer record.mutex.Unlock()
+	for i := range record.Recordings {
+		if record.Recordings[i].Path == recording.Path {
+			record.Recordings[i] = recording
+			return
+		}
+	}
+	record.Recordings = append(record.Recordings, recording)
+}
+
+// Snapshot returns a copy safe to persist while the call goes on.
+func (record *CallRecord) Snapshot() *CallRecord {
+	record.mutex.Lock()
+	defer record.mutex.Unlock()
+	snapshot := &CallRecord{
+		CallId:       record.CallId,
+		ChannelId:    record.ChannelId,
+		Flow:         record.Flow,
+		ANI:          record.ANI,
+		DNIS:         record.DNIS,
+		StartTime:    record.StartTime,
+		AnswerTime:   record.AnswerTime,
+		EndTime:      record.EndTime,
+		HangupCause:  record.HangupCause,
+		NoInputTimes: record.NoInputTimes,
+		NoMatchTimes: record.NoMatchTimes,
+		Outcome:      record.Outcome,
+	}
+	snapshot.Path = append([]NodeVisit{}, record.Path...)
+	snapshot.Inputs = append([]CollectedInput{}, record.Inputs...)
+	snapshot.Recordings = append([]Recording{}, record.Recordings...)
+	return snapshot
+}
+
+// finishRecord completes the record of ivrChannel when the call ends.
+func (ivrChannel *IVRChannel) finishRecord() *CallRecord {
+
+	record := ivrChannel.Record
+	record.mutex.Lock()
+	record.CallId = ivrChannel.Vars.Get("callId")
+	if record.CallId == "" {
+		record.CallId = ivrChannel.ChannelId
+	}
+	record.ChannelId = ivrChannel.ChannelId
+	record.Flow = ivrChannel.Flow
+	record.ANI = ivrChannel.Vars.Get("ANI")
+	record.DNIS = ivrChannel.Vars.Get("DNIS")
+	record.EndTime = time.Now()
+	record.NoInputTimes = ivrChannel.NoInputTimes
+	record.NoMatchTimes = ivrChannel.NoMatchTimes
+	if n := len(record.Path); n > 0 && record.Path[n-1].LeaveTime.IsZero() {
+		record.Path[n-1].LeaveTime = record.EndTime
+	}
+	record.mutex.Unlock()
+
+	// The caller hung up before the flow reached an end.
+	record.SetOutcome(Outcome_Abandoned)
+	return record.Snapshot()
+}
+
+// recordInput adds a collected input to the call record,masked when sensitive.
+func (ivrChannel *IVRChannel) recordInput(value string, sensitive bool, result string) {
+	input := CollectedInput{Node: ivrChannel.ActiveNode, Value: value, Sensitive: sensitive, Result: result}
+	if sensitive {
+		input.Value = calllog.Mask(value)
+	}
+	ivrChannel.Record.AddInput(input)
+}
@@ -0,0 +1,117 @@
+// fs/ivr/ CallVars
+
+package ivr
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const Channel_Var_Prefix string = "variable_"
+
+var varRex = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// Var is a flow variable declared in <Vars>,its value is the default of each call.
+type Var struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type Vars struct {
+	Var []Var
+}
+
+// CallVars are the variables of a call,initialized from the channel variables
+// and written by the nodes.They are read and written from the event goroutine
+// as well as the flow,so every access is locked.
+type CallVars struct {
+	mutex sync.RWMutex
+	vars  map[string]string
+}
+
+func NewCallVars() *CallVars {
+	vars := new(CallVars)
+	vars.vars = make(map[string]string)
+	return vars
+}
+
+func (vars *CallVars) Get(name string) string {
+	vars.mutex.RLock()
+	defer vars.mutex.RUnlock()
+	return vars.vars[name]
+}
+
+func (vars *CallVars) Lookup(name string) (string, bool) {
+	vars.mutex.RLock()
+	defer vars.mutex.RUnlock()
+	value, ok := vars.vars[name]
+	return value, ok
+}
+
+func (vars *CallVars) Set(name, value string) {
+	vars.mutex.Lock()
+	defer vars.mutex.Unlock()
+	vars.vars[name] = value
+}
+
+// SetDefault sets name unless it is already set.
+func (vars *CallVars) SetDefault(name, value string) {
+	vars.mutex.Lock()
+	defer vars.mutex.Unlock()
+	if _, ok := vars.vars[name]; !ok {
+		vars.vars[name] = value
+	}
+}
+
+// SetChannelVars sets the channel variables (variable_<name> headers) of event.
+func (vars *CallVars) SetChannelVars(header map[string]string) {
+	vars.mutex.Lock()
+	defer vars.mutex.Unlock()
+	for name, value := range header {
+		if strings.HasPrefix(name, Channel_Var_Prefix) {
+			vars.vars[strings.TrimPrefix(name, Channel_Var_Prefix)] = value
+		}
+	}
+}
+
+// Map returns a copy of the variables.
+func (vars *CallVars) Map() map[string]string {
+	vars.mutex.RLock()
+	defer vars.mutex.RUnlock()
+	copied := make(map[string]string, len(vars.vars))
+	for name, value := range vars.vars {
+		copied[name] = value
+	}
+	return copied
+}
+
+// Expand replaces ${name} in text with the variable,unknown names are replaced
+// with empty string.
+func (vars *CallVars) Expand(text string) string {
+	return vars.expand(text, func(value string) string { return value })
+}
+
+// ExpandRegexp is Expand for a regular expression,the values match literally.
+func (vars *CallVars) ExpandRegexp(text string) string {
+	return vars.expand(text, regexp.QuoteMeta)
+}
+
+// ExpandURL is Expand for an URL,the values are escaped.
+func (vars *CallVars) ExpandURL(text string) string {
+	return vars.expand(text, func(value string) string {
+		return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+	})
+}
+
+func (vars *CallVars) expand(text string, quote func(string) string) string {
+	if !strings.Contains(text, "${") {
+		return text
+	}
+	vars.mutex.RLock()
+	defer vars.mutex.RUnlock()
+	return varRex.ReplaceAllStringFunc(text, func(ref string) string {
+		return quote(vars.vars[strings.TrimSpace(ref[2:len(ref)-1])])
+	})
+}
@@ -0,0 +1,32 @@
+// CallVars test
+
+package ivr
+
+import (
+	"testing"
+)
+
+func TestCallVars(t *testing.T) {
+
+	vars := NewCallVars()
+	vars.SetChannelVars(map[string]string{"variable_sip_from_user": "1001", "Caller-Caller-Id-Number": "1001"})
+	vars.Set("ANI", "1001")
+	vars.SetDefault("ANI", "0000")
+	vars.SetDefault("hotline", "95588")
+
+	if value, ok := vars.Lookup("sip_from_user"); !ok || value != "1001" {
+		t.Fatalf("Channel var sip_from_user=%s", value)
+	}
+	if _, ok := vars.Lookup("Caller-Caller-Id-Number"); ok {
+		t.Fatalf("Event header is not a channel var")
+	}
+	if text := vars.Expand("${ANI} calls ${ hotline }${none}"); text != "1001 calls 95588" {
+		t.Fatalf("Expand=%s", text)
+	}
+
+	vars.Set("prefix", "6.2")
+	if express := vars.ExpandRegexp(`^${prefix}\d+$`); express != `^6\.2\d+$` {
+		t.Fatalf("ExpandRegexp=%s", express)
+	}
+	t.Log("Test pass.")
+}
@@ -0,0 +1,74 @@
+// fs/ivr/ ConditionNode
+
+package ivr
+
+import (
+	"errors"
+)
+
+type Condition struct {
+	Expr     string `xml:"expr,attr"`
+	NextNode string `xml:"nextNode,attr"`
+
+	expression *Expression
+}
+
+type Conditions struct {
+	Condition []Condition
+}
+
+// ConditionNode branches to the NextNode of the first Condition whose expression
+// is true,or to Default.An expression which fails to evaluate is false.
+type ConditionNode struct {
+	NodeName   string `xml:"name,attr"`
+	Conditions Conditions
+	Default    string
+}
+
+// Load compiles the expressions,a syntax error fails the flow file.
+func (node ConditionNode) Load(dir string) (IVRNode, error) {
+	conditions := make([]Condition, len(node.Conditions.Condition))
+	for i, condition := range node.Conditions.Condition {
+		expression, err := CompileExpression(condition.Expr)
+		if err != nil {
+			return nil, errors.New("Invalid condition [" + condition.Expr + "] : " + err.Error())
+		}
+		condition.expression = expression
+		conditions[i] = condition
+	}
+	node.Conditions.Condition = conditions
+	return node, nil
+}
+
+func (node ConditionNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	for _, condition := range node.Conditions.Condition {
+		expression := condition.expression
+		if expression == nil {
+			compiled, err := CompileExpression(condition.Expr)
+			if err != nil {
+				ivrChannel.Log.Warn("Compile condition [%s] failure for %s", condition.Expr, err.Error())
+				continue
+			}
+			expression = compiled
+		}
+		match, err := expression.EvalBool(ivrChannel.Vars)
+		if err != nil {
+			ivrChannel.Log.Warn("Evaluate condition [%s] failure for %s", condition.Expr, err.Error())
+			continue
+		}
+		if match {
+			ivrChannel.Log.Debug("Condition [%s] is true,nextNode=%s", condition.Expr, condition.NextNode)
+			return condition.NextNode, nil
+		}
+	}
+
+	ivrChannel.Log.Debug("No condition is true,nextNode=%s", node.Default)
+	return node.Default, nil
+}
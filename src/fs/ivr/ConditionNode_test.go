@@ -0,0 +1,44 @@
+// ConditionNode test
+
+package ivr
+
+import (
+	"fs/ivr/calllog"
+	"testing"
+)
+
+func TestConditionNodeLoad(t *testing.T) {
+
+	node := ConditionNode{
+		NodeName: "vipCheck",
+		Conditions: Conditions{Condition: []Condition{
+			{Expr: "balance < 0", NextNode: "overdue"},
+			{Expr: "vip == 'true'", NextNode: "vipMenu"},
+		}},
+		Default: "mainMenu",
+	}
+
+	loaded, err := node.Load("")
+	if err != nil {
+		t.Fatalf("Load condition node failure for %s", err.Error())
+	}
+	for _, condition := range loaded.(ConditionNode).Conditions.Condition {
+		if condition.expression == nil {
+			t.Fatalf("Condition [%s] is not compiled", condition.Expr)
+		}
+	}
+
+	ivrChannel := &IVRChannel{Vars: NewCallVars(), Log: calllog.New()}
+	ivrChannel.Vars.Set("balance", "10")
+	ivrChannel.Vars.Set("vip", "true")
+	if next, err := loaded.Execute(ivrChannel); err != nil || next != "vipMenu" {
+		t.Fatalf("Execute next=%s,err=%v,expect vipMenu", next, err)
+	}
+
+	node.Conditions.Condition[1].Expr = "vip == "
+	if _, err := node.Load(""); err == nil {
+		t.Fatal("Invalid condition,expect error")
+	}
+
+	t.Log("Test pass.")
+}
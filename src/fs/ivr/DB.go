@@ -9,17 +9,98 @@ package ivr
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"fs/ivr/eventsocket"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const DB_Type_MySQL string = "mysql"
+const DB_Type_SQLite string = "sqlite3"
+const DB_Type_Postgres string = "postgres"
+
 type Persistor interface {
 	Open() error
 	Persist(ivrChannel *IVRChannel)
 	Close()
 }
 
+// NewPersistor creates the persistor selected by config,records of every
+// store but none are written through an AsyncPersistor.
+func NewPersistor(config Persistence) (Persistor, error) {
+
+	config = config.withDefaults()
+
+	var writer RecordWriter
+	switch config.Type {
+	case Persist_Type_None:
+		return new(NoopPersistor), nil
+	case Persist_Type_MySQL, Persist_Type_SQLite, Persist_Type_Postgres:
+		writer = newConfigDBPersistor(config)
+	case Persist_Type_JSONL:
+		writer = NewFilePersistor(config.File, int64(config.MaxSize)<<20, config.Daily)
+	default:
+		return nil, errors.New("Unknown persistence type " + config.Type)
+	}
+
+	return NewAsyncPersistor(writer, config), nil
+}
+
+// newConfigDBPersistor returns the database persistor of config,nil when
+// config.Type is not a database.
+func newConfigDBPersistor(config Persistence) *DBPersistor {
+	dbType := ""
+	switch config.Type {
+	case Persist_Type_MySQL:
+		dbType = DB_Type_MySQL
+	case Persist_Type_SQLite:
+		dbType = DB_Type_SQLite
+	case Persist_Type_Postgres:
+		dbType = DB_Type_Postgres
+	default:
+		return nil
+	}
+	return NewDBPersistor(dbType, config.DBAddr, config.DBUser, config.DBPwd, config.DBName)
+}
+
+// MySQL errors of a bad record : duplicate entry,null in a not null column,
+// out of range value,incorrect value,too long value,missing foreign key.
+var mysqlRecordErrors = map[uint16]bool{1062: true, 1048: true, 1264: true, 1292: true, 1366: true, 1406: true, 1452: true}
+
+// isConstraintError reports whether err is the database refusing the
+// record itself,writing it again can never succeed.
+func isConstraintError(err error) bool {
+	switch dbErr := err.(type) {
+	case *mysql.MySQLError:
+		return mysqlRecordErrors[dbErr.Number]
+	case sqlite3.Error:
+		return dbErr.Code == sqlite3.ErrConstraint || dbErr.Code == sqlite3.ErrTooBig || dbErr.Code == sqlite3.ErrMismatch
+	case *pq.Error:
+		// Class 22 data exception,23 integrity constraint violation.
+		return dbErr.Code.Class() == "22" || dbErr.Code.Class() == "23"
+	}
+	return false
+}
+
+// NoopPersistor discards call records.
+type NoopPersistor struct {
+}
+
+func (persistor *NoopPersistor) Open() error {
+	return nil
+}
+
+func (persistor *NoopPersistor) Persist(ivrChannel *IVRChannel) {
+}
+
+func (persistor *NoopPersistor) Close() {
+}
+
 type DBPersistor struct {
 	DBType string
 	DBAddr string
@@ -39,33 +120,145 @@ func NewDBPersistor(dbType, dbAddr, dbUser, dbPwd, dbName string) *DBPersistor {
 	return persistor
 }
 
+// DSN returns the data source name of DBType,DBName is the file of sqlite3.
+func (persistor *DBPersistor) DSN() string {
+	switch persistor.DBType {
+	case DB_Type_SQLite:
+		return persistor.DBName
+	case DB_Type_Postgres:
+		dsn := url.URL{Scheme: "postgres", User: url.UserPassword(persistor.DBUser, persistor.DBPwd), Host: persistor.DBAddr, Path: persistor.DBName, RawQuery: "sslmode=disable"}
+		return dsn.String()
+	default:
+		return fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=true", persistor.DBUser, persistor.DBPwd, persistor.DBAddr, persistor.DBName)
+	}
+}
+
+// rebind turns the ? placeholders into $1,$2... for postgres.
+func (persistor *DBPersistor) rebind(query string) string {
+	if persistor.DBType != DB_Type_Postgres {
+		return query
+	}
+	buf := make([]string, 0)
+	for i, part := range strings.Split(query, "?") {
+		if i > 0 {
+			buf = append(buf, "$"+strconv.Itoa(i))
+		}
+		buf = append(buf, part)
+	}
+	return strings.Join(buf, "")
+}
+
+// Open connects the database and checks its schema.
 func (persistor *DBPersistor) Open() error {
+	if err := persistor.connect(); err != nil {
+		return err
+	}
+	return persistor.CheckSchema()
+}
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8", persistor.DBUser, persistor.DBPwd, persistor.DBAddr, persistor.DBName)
-	fmt.Println("DSN :", dsn)
+func (persistor *DBPersistor) connect() error {
 
-	db, err := sql.Open(persistor.DBType, dsn)
+	fmt.Println("Open database :", persistor.DBType, persistor.DBAddr, persistor.DBName)
+
+	db, err := sql.Open(persistor.DBType, persistor.DSN())
 	if err != nil {
 		fmt.Println("Open database failure for :", err.Error())
 		return err
 	}
 
-	fmt.Println("Open mysql db ok.")
-
+	// The handle stays usable when the server is down,it reconnects later.
 	persistor.DB = db
+	if err = db.Ping(); err != nil {
+		fmt.Println("Ping database failure for :", err.Error())
+		return err
+	}
+
+	fmt.Println("Open database ok.")
 
 	return nil
 }
 
+// Persist writes the call detail record of ivrChannel when the call ends.
 func (persistor *DBPersistor) Persist(ivrChannel *IVRChannel) {
-	stmt, _ := persistor.DB.Prepare("insert into IvrNode values(?,?,?,?)")
-	uuid, _ := eventsocket.GenUUID()
-	stmt.Exec(uuid, ivrChannel.ActiveNode, eventsocket.GetDateTime(), ivrChannel.ChannelId)
-	defer stmt.Close()
+	record := ivrChannel.finishRecord()
+	if err := persistor.WriteRecord(record); err != nil {
+		ivrChannel.Log.Error("Persist call record failure for %s", err.Error())
+	}
+}
+
+func (persistor *DBPersistor) WriteRecord(record *CallRecord) error {
+	return persistor.WriteRecords([]*CallRecord{record})
+}
+
+// WriteRecords writes a batch of records in one transaction.
+func (persistor *DBPersistor) WriteRecords(records []*CallRecord) error {
+
+	if persistor.DB == nil {
+		return errors.New("Database not open")
+	}
+
+	tx, err := persistor.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err = persistor.writeRecord(tx, record); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (persistor *DBPersistor) writeRecord(tx *sql.Tx, record *CallRecord) error {
+
+	_, err := tx.Exec(persistor.rebind("insert into IvrCdr(CallId,ChannelId,Flow,ANI,DNIS,StartTime,AnswerTime,EndTime,HangupCause,Outcome,NoInputTimes,NoMatchTimes) values(?,?,?,?,?,?,?,?,?,?,?,?)"),
+		record.CallId, record.ChannelId, record.Flow, record.ANI, record.DNIS, record.StartTime, nullTime(record.AnswerTime), record.EndTime,
+		record.HangupCause, record.Outcome, record.NoInputTimes, record.NoMatchTimes)
+	if err != nil {
+		return err
+	}
+
+	for seq, visit := range record.Path {
+		_, err = tx.Exec(persistor.rebind("insert into IvrNodeVisit(CallId,Seq,Node,EnterTime,LeaveTime,Result) values(?,?,?,?,?,?)"),
+			record.CallId, seq, visit.Node, visit.EnterTime, nullTime(visit.LeaveTime), visit.Result)
+		if err != nil {
+			return err
+		}
+	}
+
+	for seq, input := range record.Inputs {
+		_, err = tx.Exec(persistor.rebind("insert into IvrInput(CallId,Seq,Node,Value,Sensitive,Result,InputTime) values(?,?,?,?,?,?,?)"),
+			record.CallId, seq, input.Node, input.Value, input.Sensitive, input.Result, input.InputTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	for seq, recording := range record.Recordings {
+		_, err = tx.Exec(persistor.rebind("insert into IvrRecording(CallId,Seq,Node,Path,Duration,Reason,RecordTime) values(?,?,?,?,?,?,?)"),
+			record.CallId, seq, recording.Node, recording.Path, recording.Duration, recording.Reason, recording.RecordTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
 }
 
 func (persistor *DBPersistor) Close() {
-	persistor.DB.Close()
+	if persistor.DB != nil {
+		persistor.DB.Close()
+	}
 }
 
 func DBDemo(dbAddr, dbUser, dbPwd, dbName string) {
@@ -79,7 +272,7 @@ func DBDemo(dbAddr, dbUser, dbPwd, dbName string) {
 		return
 	}
 
-	fmt.Println("Open mysql db ok.")
+	fmt.Println("Open database ok.")
 
 	stmt, err := db.Prepare("insert into IvrNode values(?,?,?,?)")
 	if err != nil {
@@ -0,0 +1,281 @@
+// fs/ivr/ DBQueryNode
+
+package ivr
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fs/ivr/calllog"
+	"strings"
+	"time"
+)
+
+const Default_Query_Timeout int = 3000
+
+const Query_Result_Found string = "found"
+const Query_Result_NotFound string = "notfound"
+const Query_Result_Error string = "error"
+
+var queryDBNotOpenErr error = errors.New("Query database not open")
+
+// Query is a named query of the flow file,parameters are written :name and
+// bound to call variable name unless the node maps them,eg.
+// select Name,Balance from Account where Phone = :ANI
+type Query struct {
+	QName string `xml:"name,attr"`
+	SQL   string `xml:",chardata"`
+}
+
+type Queries struct {
+	Query []Query
+}
+
+// QueryDBConfig is the database of DBQueryNode,Type is mysql,sqlite or postgres.
+type QueryDBConfig struct {
+	Type     string
+	DBAddr   string
+	DBUser   string
+	DBPwd    string
+	DBName   string // Database file of sqlite.
+	MaxConns int
+}
+
+// QueryDB runs the queries of DBQueryNode on the connection of a DBPersistor.
+type QueryDB struct {
+	persistor *DBPersistor
+}
+
+var queryDB *QueryDB = nil
+
+// OpenQueryDB connects the database of config,a database down at startup
+// is reconnected by the first query.
+func OpenQueryDB(config QueryDBConfig) (*QueryDB, error) {
+
+	persistor := newConfigDBPersistor(Persistence{Type: config.Type, DBAddr: config.DBAddr, DBUser: config.DBUser, DBPwd: config.DBPwd, DBName: config.DBName})
+	if persistor == nil {
+		return nil, errors.New("Unknown query database type " + config.Type)
+	}
+
+	err := persistor.connect()
+	if persistor.DB == nil {
+		return nil, err
+	}
+	if config.MaxConns > 0 {
+		persistor.DB.SetMaxOpenConns(config.MaxConns)
+	}
+	return &QueryDB{persistor: persistor}, err
+}
+
+func (db *QueryDB) Close() {
+	db.persistor.Close()
+}
+
+// bindQuery replaces the :name parameters of query with placeholders of the
+// database and returns the parameter names in order.Quoted text and :: casts
+// of postgres are kept.
+func bindQuery(query string) (string, []string) {
+
+	var buf strings.Builder
+	names := make([]string, 0)
+	quote := byte(0)
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			buf.WriteString("::")
+			i++
+			continue
+		case c == ':' && i+1 < len(query) && isParamChar(query[i+1], true):
+			end := i + 1
+			for end < len(query) && isParamChar(query[end], false) {
+				end++
+			}
+			names = append(names, query[i+1:end])
+			buf.WriteByte('?')
+			i = end - 1
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String(), names
+}
+
+func isParamChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+// QueryRow runs the named query and returns the columns of its first row,
+// nil when there is no row.
+func (db *QueryDB) QueryRow(timeoutMs int, query string, args ...interface{}) (map[string]string, error) {
+
+	if db == nil || db.persistor.DB == nil {
+		return nil, queryDBNotOpenErr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+
+	rows, err := db.persistor.DB.QueryContext(ctx, db.persistor.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]string, len(columns))
+	for i, column := range columns {
+		row[column] = values[i].String
+	}
+	return row, nil
+}
+
+// QueryParam binds parameter Name to call variable Var or to Value,${name}
+// in Value is replaced with the variable.
+type QueryParam struct {
+	Name  string `xml:"name,attr"`
+	Var   string `xml:"var,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type QueryParams struct {
+	Param []QueryParam
+}
+
+// QueryColumn copies column Name of the row to call variable Var.
+type QueryColumn struct {
+	Name string `xml:"name,attr"`
+	Var  string `xml:"var,attr"`
+}
+
+type QueryColumns struct {
+	Column []QueryColumn
+}
+
+// DBQueryNode runs a query of the flow file with call variables as parameters.
+// The columns of the first row are copied to call variables,every column to
+// variable <query>_<column> when no Columns are given,so a column cannot
+// overwrite a variable of the call,eg. ANI.The next node is Found,
+// NotFound or OnError and the result is kept in call variable query_result.
+type DBQueryNode struct {
+	NodeName string `xml:"name,attr"`
+	Query    string // Name of the query.
+	Params   QueryParams
+	Columns  QueryColumns
+	Timeout  int // Millisecond.
+	Found    string
+	NotFound string
+	OnError  string
+}
+
+func (node DBQueryNode) args(names []string, vars *CallVars) []interface{} {
+	args := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		value := vars.Get(name)
+		for _, param := range node.Params.Param {
+			if param.Name != name {
+				continue
+			}
+			if param.Var != "" {
+				value = vars.Get(param.Var)
+			} else {
+				value = vars.Expand(param.Value)
+			}
+			break
+		}
+		args = append(args, value)
+	}
+	return args
+}
+
+func (node DBQueryNode) branch(result string) string {
+	switch result {
+	case Query_Result_Found:
+		return node.Found
+	case Query_Result_NotFound:
+		return node.NotFound
+	}
+	return node.OnError
+}
+
+// query runs the query on db and returns the result.
+func (node DBQueryNode) query(db *QueryDB, vars *CallVars, log *calllog.Logger) string {
+
+	query, ok := ivrQueryMap[node.Query]
+	if !ok {
+		log.Warn("Query %s not find", node.Query)
+		return Query_Result_Error
+	}
+
+	timeoutMs := node.Timeout
+	if timeoutMs <= 0 {
+		timeoutMs = Default_Query_Timeout
+	}
+
+	sqlText, names := bindQuery(strings.TrimSpace(query.SQL))
+	start := time.Now()
+	row, err := db.QueryRow(timeoutMs, sqlText, node.args(names, vars)...)
+	if err != nil {
+		log.Warn("Query %s failure for %s", node.Query, err.Error())
+		return Query_Result_Error
+	}
+	if row == nil {
+		log.Info("Query %s no row in %dms", node.Query, time.Since(start)/time.Millisecond)
+		return Query_Result_NotFound
+	}
+
+	if len(node.Columns.Column) > 0 {
+		for _, column := range node.Columns.Column {
+			if value, ok := row[column.Name]; ok {
+				vars.Set(column.Var, value)
+			} else {
+				log.Debug("Column %s not find in query %s", column.Name, node.Query)
+			}
+		}
+	} else {
+		for column, value := range row {
+			vars.Set(node.Query+"_"+column, value)
+		}
+	}
+	log.Info("Query %s found in %dms", node.Query, time.Since(start)/time.Millisecond)
+	return Query_Result_Found
+}
+
+func (node DBQueryNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	result := node.query(queryDB, ivrChannel.Vars, ivrChannel.Log)
+	ivrChannel.Vars.Set("query_result", result)
+
+	return node.branch(result), nil
+}
@@ -0,0 +1,99 @@
+// DBQueryNode test
+
+package ivr
+
+import (
+	"fs/ivr/calllog"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBindQuery(t *testing.T) {
+
+	query, names := bindQuery("select Name from Account where Phone = :ANI and Note <> ':x' and Pin = :pin_1 and Day = :day::date")
+	if query != "select Name from Account where Phone = ? and Note <> ':x' and Pin = ? and Day = ?::date" {
+		t.Fatalf("bindQuery=%s", query)
+	}
+	if !reflect.DeepEqual(names, []string{"ANI", "pin_1", "day"}) {
+		t.Fatalf("names=%v,expect [ANI pin_1 day]", names)
+	}
+
+	t.Log("Test pass.")
+}
+
+func TestDBQueryNode(t *testing.T) {
+
+	db, err := OpenQueryDB(QueryDBConfig{Type: Persist_Type_SQLite, DBName: filepath.Join(t.TempDir(), "crm.db")})
+	if err != nil {
+		t.Fatalf("OpenQueryDB failure for %s", err.Error())
+	}
+	defer db.Close()
+
+	for _, statement := range []string{
+		"create table Account(Phone varchar(32),Name varchar(64),Pin varchar(8),Balance decimal(12,2),Level int)",
+		"insert into Account values('13800138000','Tom','1234',1024.5,3)",
+	} {
+		if _, err := db.persistor.DB.Exec(statement); err != nil {
+			t.Fatalf("Exec failure for %s", err.Error())
+		}
+	}
+
+	ivrQueryMap["accountByAni"] = Query{QName: "accountByAni", SQL: "select Name,Balance,Level as level from Account where Phone = :ANI and Pin = :pin"}
+	ivrQueryMap["badQuery"] = Query{QName: "badQuery", SQL: "select Name from NoTable"}
+	defer delete(ivrQueryMap, "accountByAni")
+	defer delete(ivrQueryMap, "badQuery")
+
+	vars := NewCallVars()
+	vars.Set("ANI", "13800138000")
+	vars.Set("DtmfValue", "1234")
+	log := calllog.New()
+
+	node := DBQueryNode{
+		NodeName: "account",
+		Query:    "accountByAni",
+		Params:   QueryParams{Param: []QueryParam{{Name: "pin", Var: "DtmfValue"}}},
+		Columns:  QueryColumns{Column: []QueryColumn{{Name: "Name", Var: "name"}, {Name: "Balance", Var: "balance"}}},
+		Found:    "found",
+		NotFound: "notfound",
+		OnError:  "error",
+	}
+	if result := node.query(db, vars, log); result != Query_Result_Found {
+		t.Fatalf("query=%s,expect %s", result, Query_Result_Found)
+	}
+	if vars.Get("name") != "Tom" || vars.Get("balance") != "1024.5" || vars.Get("level") != "" {
+		t.Fatalf("name=%s,balance=%s,level=%s,expect Tom,1024.5 and no level", vars.Get("name"), vars.Get("balance"), vars.Get("level"))
+	}
+
+	// Without Columns a column named like a call variable does not overwrite it.
+	ivrQueryMap["accountByAni"] = Query{QName: "accountByAni", SQL: "select Name as ANI,Level as level from Account where Phone = :ANI and Pin = :pin"}
+	node.Columns = QueryColumns{}
+	if result := node.query(db, vars, log); result != Query_Result_Found || vars.Get("accountByAni_level") != "3" {
+		t.Fatalf("query=%s,accountByAni_level=%s,expect found,3", result, vars.Get("accountByAni_level"))
+	}
+	if vars.Get("ANI") != "13800138000" || vars.Get("accountByAni_ANI") != "Tom" || vars.Get("level") != "" {
+		t.Fatalf("ANI=%s,accountByAni_ANI=%s,level=%s,expect prefixed columns only", vars.Get("ANI"), vars.Get("accountByAni_ANI"), vars.Get("level"))
+	}
+
+	vars.Set("DtmfValue", "0000")
+	if result := node.query(db, vars, log); result != Query_Result_NotFound || node.branch(result) != "notfound" {
+		t.Fatalf("query=%s,expect %s", result, Query_Result_NotFound)
+	}
+
+	node.Query = "badQuery"
+	if result := node.query(db, vars, log); result != Query_Result_Error || node.branch(result) != "error" {
+		t.Fatalf("query=%s,expect %s", result, Query_Result_Error)
+	}
+
+	node.Query = "noQuery"
+	if result := node.query(db, vars, log); result != Query_Result_Error {
+		t.Fatalf("query=%s,expect %s", result, Query_Result_Error)
+	}
+
+	node.Query = "accountByAni"
+	if result := node.query(nil, vars, log); result != Query_Result_Error {
+		t.Fatalf("query without database=%s,expect %s", result, Query_Result_Error)
+	}
+
+	t.Log("Test pass.")
+}
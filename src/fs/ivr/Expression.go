@@ -0,0 +1,629 @@
+// fs/ivr/ Expression
+
+package ivr
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a compiled expression over the call variables,eg.
+//
+//	ANI in ('13800138000', '13900139000')
+//	language == 'en' and balance >= 1000
+//	DNIS =~ '^400' or not (bridge_result != 'answered')
+//	'Dear ' + name
+//
+// Bare names and ${name} are call variables,strings are quoted with ' or ".
+// Operators : or || and && not ! == != < <= > >= =~ (regex match) !~ in,not in,
+// + - * / %,and the words eq ne lt le gt ge so no XML escape is needed.
+// Two strings are equal only when identical,so '0138' != '138'.Other values
+// compare as numbers when both sides are numbers,else as strings.
+// + adds only numbers (number literals and results of - * / % len number),
+// anything else is joined as strings,so '0' + ANI keeps the leading zero.
+// Functions : len(x) lower(x) upper(x) trim(x) empty(x) number(x).
+type Expression struct {
+	Source string
+	eval   evalFunc
+}
+
+type evalFunc func(vars *CallVars) (interface{}, error)
+
+type exprToken struct {
+	kind  int
+	text  string
+	value interface{}
+}
+
+const (
+	token_End = iota
+	token_Number
+	token_String
+	token_Name
+	token_Var
+	token_Op
+)
+
+var exprWordOps = map[string]string{
+	"and": "&&",
+	"or":  "||",
+	"not": "!",
+	"eq":  "==",
+	"ne":  "!=",
+	"lt":  "<",
+	"le":  "<=",
+	"gt":  ">",
+	"ge":  ">=",
+	"in":  "in",
+}
+
+var exprFuncs = map[string]func(value interface{}) interface{}{
+	"len":   func(value interface{}) interface{} { return float64(len([]rune(exprString(value)))) },
+	"lower": func(value interface{}) interface{} { return strings.ToLower(exprString(value)) },
+	"upper": func(value interface{}) interface{} { return strings.ToUpper(exprString(value)) },
+	"trim":  func(value interface{}) interface{} { return strings.TrimSpace(exprString(value)) },
+	"empty": func(value interface{}) interface{} { return exprString(value) == "" },
+	"number": func(value interface{}) interface{} {
+		if number, ok := exprNumber(value); ok {
+			return number
+		}
+		return value
+	},
+}
+
+func CompileExpression(source string) (*Expression, error) {
+	tokens, err := scanExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	parser := &exprParser{tokens: tokens}
+	eval, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.peek().kind != token_End {
+		return nil, errors.New("Unexpected " + parser.peek().text + " in expression " + source)
+	}
+	return &Expression{Source: source, eval: eval}, nil
+}
+
+// EvalExpression compiles and evaluates source.
+func EvalExpression(source string, vars *CallVars) (interface{}, error) {
+	expression, err := CompileExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	return expression.Eval(vars)
+}
+
+func (expression *Expression) Eval(vars *CallVars) (interface{}, error) {
+	return expression.eval(vars)
+}
+
+func (expression *Expression) EvalBool(vars *CallVars) (bool, error) {
+	value, err := expression.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	return exprBool(value), nil
+}
+
+func scanExpression(source string) ([]exprToken, error) {
+
+	tokens := make([]exprToken, 0)
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			number, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, errors.New("Invalid number " + string(runes[start:i]))
+			}
+			tokens = append(tokens, exprToken{kind: token_Number, text: string(runes[start:i]), value: number})
+		case c == '\'' || c == '"':
+			var text []rune
+			i++
+			for i < len(runes) && runes[i] != c {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				text = append(text, runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, errors.New("Unterminated string in expression " + source)
+			}
+			i++
+			tokens = append(tokens, exprToken{kind: token_String, text: string(text), value: string(text)})
+		case c == '$' && i+1 < len(runes) && runes[i+1] == '{':
+			end := i + 2
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, errors.New("Unterminated ${ in expression " + source)
+			}
+			name := strings.TrimSpace(string(runes[i+2 : end]))
+			i = end + 1
+			tokens = append(tokens, exprToken{kind: token_Var, text: name})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			word := string(runes[start:i])
+			if op, ok := exprWordOps[strings.ToLower(word)]; ok {
+				tokens = append(tokens, exprToken{kind: token_Op, text: op})
+			} else {
+				tokens = append(tokens, exprToken{kind: token_Name, text: word})
+			}
+		default:
+			op := ""
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "==", "!=", "<=", ">=", "=~", "!~", "&&", "||":
+					op = two
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("<>!+-*/%(),[]", c) {
+					return nil, errors.New("Unexpected " + string(c) + " in expression " + source)
+				}
+				op = string(c)
+			}
+			i = i + len(op)
+			tokens = append(tokens, exprToken{kind: token_Op, text: op})
+		}
+	}
+	return append(tokens, exprToken{kind: token_End, text: "end"}), nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (parser *exprParser) peek() exprToken {
+	return parser.tokens[parser.pos]
+}
+
+func (parser *exprParser) next() exprToken {
+	token := parser.tokens[parser.pos]
+	if token.kind != token_End {
+		parser.pos++
+	}
+	return token
+}
+
+func (parser *exprParser) isOp(op string) bool {
+	token := parser.peek()
+	return token.kind == token_Op && token.text == op
+}
+
+func (parser *exprParser) expect(op string) error {
+	if !parser.isOp(op) {
+		return errors.New("Expect " + op + " but " + parser.peek().text)
+	}
+	parser.next()
+	return nil
+}
+
+func (parser *exprParser) parseOr() (evalFunc, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for parser.isOp("||") {
+		parser.next()
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = func(l, r evalFunc) evalFunc {
+			return func(vars *CallVars) (interface{}, error) {
+				value, err := l(vars)
+				if err != nil || exprBool(value) {
+					return exprBool(value), err
+				}
+				value, err = r(vars)
+				return exprBool(value), err
+			}
+		}(left, right)
+	}
+	return left, nil
+}
+
+func (parser *exprParser) parseAnd() (evalFunc, error) {
+	left, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for parser.isOp("&&") {
+		parser.next()
+		right, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = func(l, r evalFunc) evalFunc {
+			return func(vars *CallVars) (interface{}, error) {
+				value, err := l(vars)
+				if err != nil || !exprBool(value) {
+					return false, err
+				}
+				value, err = r(vars)
+				return exprBool(value), err
+			}
+		}(left, right)
+	}
+	return left, nil
+}
+
+func (parser *exprParser) parseNot() (evalFunc, error) {
+	if parser.isOp("!") {
+		parser.next()
+		operand, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars *CallVars) (interface{}, error) {
+			value, err := operand(vars)
+			return !exprBool(value), err
+		}, nil
+	}
+	return parser.parseCompare()
+}
+
+func (parser *exprParser) parseCompare() (evalFunc, error) {
+	left, err := parser.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+
+	token := parser.peek()
+	if token.kind != token_Op {
+		return left, nil
+	}
+
+	op := token.text
+	switch op {
+	case "!":
+		// not in
+		if parser.tokens[parser.pos+1].kind == token_Op && parser.tokens[parser.pos+1].text == "in" {
+			parser.next()
+			parser.next()
+			list, err := parser.parseList()
+			if err != nil {
+				return nil, err
+			}
+			return func(vars *CallVars) (interface{}, error) {
+				found, err := exprIn(left, list, vars)
+				return !found, err
+			}, nil
+		}
+		return left, nil
+	case "in":
+		parser.next()
+		list, err := parser.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars *CallVars) (interface{}, error) {
+			return exprIn(left, list, vars)
+		}, nil
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+		parser.next()
+	default:
+		return left, nil
+	}
+
+	start := parser.pos
+	right, err := parser.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+
+	// Compile a constant pattern once.
+	var pattern *regexp.Regexp
+	if op == "=~" || op == "!~" {
+		if constant := parser.tokens[start]; parser.pos == start+1 && constant.kind == token_String {
+			if pattern, err = regexp.Compile(constant.text); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return func(vars *CallVars) (interface{}, error) {
+		l, err := left(vars)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(vars)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "=~", "!~":
+			rex := pattern
+			if rex == nil {
+				if rex, err = regexp.Compile(exprString(r)); err != nil {
+					return nil, err
+				}
+			}
+			return rex.MatchString(exprString(l)) == (op == "=~"), nil
+		}
+		switch op {
+		case "==":
+			return exprEqual(l, r), nil
+		case "!=":
+			return !exprEqual(l, r), nil
+		}
+		compare := exprCompare(l, r)
+		switch op {
+		case "<":
+			return compare < 0, nil
+		case "<=":
+			return compare <= 0, nil
+		case ">":
+			return compare > 0, nil
+		}
+		return compare >= 0, nil
+	}, nil
+}
+
+// parseList parses (a, b, ...) or [a, b, ...],a single value is a list of one.
+func (parser *exprParser) parseList() ([]evalFunc, error) {
+	closing := ""
+	if parser.isOp("(") {
+		closing = ")"
+	} else if parser.isOp("[") {
+		closing = "]"
+	}
+	if closing == "" {
+		item, err := parser.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		return []evalFunc{item}, nil
+	}
+	parser.next()
+
+	list := make([]evalFunc, 0)
+	for !parser.isOp(closing) {
+		item, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+		if !parser.isOp(",") {
+			break
+		}
+		parser.next()
+	}
+	return list, parser.expect(closing)
+}
+
+func (parser *exprParser) parseAdd() (evalFunc, error) {
+	left, err := parser.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for parser.isOp("+") || parser.isOp("-") {
+		op := parser.next().text
+		right, err := parser.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		left = exprArith(op, left, right)
+	}
+	return left, nil
+}
+
+func (parser *exprParser) parseMul() (evalFunc, error) {
+	left, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for parser.isOp("*") || parser.isOp("/") || parser.isOp("%") {
+		op := parser.next().text
+		right, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = exprArith(op, left, right)
+	}
+	return left, nil
+}
+
+func (parser *exprParser) parseUnary() (evalFunc, error) {
+	if parser.isOp("-") {
+		parser.next()
+		operand, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprArith("-", func(vars *CallVars) (interface{}, error) { return float64(0), nil }, operand), nil
+	}
+	return parser.parsePrimary()
+}
+
+func (parser *exprParser) parsePrimary() (evalFunc, error) {
+
+	token := parser.next()
+	switch token.kind {
+	case token_Number, token_String:
+		value := token.value
+		return func(vars *CallVars) (interface{}, error) { return value, nil }, nil
+	case token_Var:
+		name := token.text
+		return func(vars *CallVars) (interface{}, error) { return vars.Get(name), nil }, nil
+	case token_Name:
+		name := token.text
+		switch strings.ToLower(name) {
+		case "true":
+			return func(vars *CallVars) (interface{}, error) { return true, nil }, nil
+		case "false":
+			return func(vars *CallVars) (interface{}, error) { return false, nil }, nil
+		}
+		if fn, ok := exprFuncs[strings.ToLower(name)]; ok && parser.isOp("(") {
+			parser.next()
+			arg, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err = parser.expect(")"); err != nil {
+				return nil, err
+			}
+			return func(vars *CallVars) (interface{}, error) {
+				value, err := arg(vars)
+				if err != nil {
+					return nil, err
+				}
+				return fn(value), nil
+			}, nil
+		}
+		return func(vars *CallVars) (interface{}, error) { return vars.Get(name), nil }, nil
+	case token_Op:
+		if token.text == "(" {
+			inner, err := parser.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return inner, parser.expect(")")
+		}
+	}
+	return nil, errors.New("Unexpected " + token.text + " in expression")
+}
+
+func exprArith(op string, left, right evalFunc) evalFunc {
+	return func(vars *CallVars) (interface{}, error) {
+		l, err := left(vars)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(vars)
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			ln, lok := l.(float64)
+			rn, rok := r.(float64)
+			if lok && rok {
+				return ln + rn, nil
+			}
+			return exprString(l) + exprString(r), nil
+		}
+		ln, lok := exprNumber(l)
+		rn, rok := exprNumber(r)
+		if !lok || !rok {
+			return nil, errors.New("Operator " + op + " needs numbers : " + exprString(l) + "," + exprString(r))
+		}
+		switch op {
+		case "-":
+			return ln - rn, nil
+		case "*":
+			return ln * rn, nil
+		}
+		if rn == 0 {
+			return nil, errors.New("Division by zero")
+		}
+		if op == "%" {
+			return math.Mod(ln, rn), nil
+		}
+		return ln / rn, nil
+	}
+}
+
+func exprIn(left evalFunc, list []evalFunc, vars *CallVars) (bool, error) {
+	value, err := left(vars)
+	if err != nil {
+		return false, err
+	}
+	for _, item := range list {
+		candidate, err := item(vars)
+		if err != nil {
+			return false, err
+		}
+		if exprEqual(value, candidate) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func exprNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+func exprString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+func exprBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		switch strings.ToLower(v) {
+		case "", "0", "false", "no":
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func exprEqual(left, right interface{}) bool {
+	l, lok := left.(string)
+	r, rok := right.(string)
+	if lok && rok {
+		return l == r
+	}
+	return exprCompare(left, right) == 0
+}
+
+// exprCompare compares as numbers when both are numbers,else as strings.
+func exprCompare(left, right interface{}) int {
+	if l, ok := exprNumber(left); ok {
+		if r, ok := exprNumber(right); ok {
+			switch {
+			case l < r:
+				return -1
+			case l > r:
+				return 1
+			}
+			return 0
+		}
+	}
+	if lb, ok := left.(bool); ok {
+		return exprCompare(strconv.FormatBool(lb), exprString(right))
+	}
+	return strings.Compare(exprString(left), exprString(right))
+}
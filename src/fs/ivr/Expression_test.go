@@ -0,0 +1,56 @@
+// Expression test
+
+package ivr
+
+import (
+	"testing"
+)
+
+func TestExpression(t *testing.T) {
+
+	vars := NewCallVars()
+	vars.Set("ANI", "13800138000")
+	vars.Set("language", "en")
+	vars.Set("balance", "1500.50")
+	vars.Set("name", "Tom")
+	vars.Set("rate", "0.3")
+
+	cases := map[string]interface{}{
+		"ANI in ('13800138000', '13900139000')":      true,
+		"${ANI} not in ['13800138000']":              false,
+		"language == 'en' and balance >= 1000":       true,
+		"language eq 'zh' or balance lt 100":         false,
+		"ANI =~ '^138' && !(balance < 2000)":         false,
+		"ANI !~ '^139'":                              true,
+		"'Dear ' + name":                             "Dear Tom",
+		"'0' + ANI":                                  "013800138000",
+		"ANI + ANI":                                  "1380013800013800138000",
+		"balance + 1":                                "1500.501",
+		"number(balance) + 1":                        float64(1501.5),
+		"balance * 1 + rate * 1":                     float64(1500.8),
+		"balance * 2 - 1":                            float64(3000),
+		"len(ANI) == 11 and upper(language) == 'EN'": true,
+		"empty(unknown) and not empty(name)":         true,
+		"ANI == '013800138000'":                      false,
+		"balance == 1500.5":                          true,
+		"(1 + 2) * 3 % 4":                            float64(1),
+		"5 % 0.5":                                    float64(0),
+		"7 % 2.5 == 2 and balance % rate < rate":     true,
+	}
+	for source, expect := range cases {
+		value, err := EvalExpression(source, vars)
+		if err != nil {
+			t.Fatalf("Eval %s failure for %s", source, err.Error())
+		}
+		if value != expect {
+			t.Fatalf("Eval %s=%v,expect %v", source, value, expect)
+		}
+	}
+
+	for _, source := range []string{"ANI ==", "'open", "a b", "balance / 0", "balance % 0", "name * 2"} {
+		if _, err := EvalExpression(source, vars); err == nil {
+			t.Fatalf("Eval %s expect error", source)
+		}
+	}
+	t.Log("Test pass.")
+}
@@ -0,0 +1,132 @@
+// fs/ivr/ FilePersistor
+
+package ivr
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// FilePersistor appends call records as JSON lines to File.The file is
+// rotated to File.<yyyyMMdd-HHmmss> when it reaches MaxSize or a new day begins.
+type FilePersistor struct {
+	File    string
+	MaxSize int64 // Byte,0 disables size rotation.
+	Daily   bool
+	mutex   sync.Mutex
+	file    *os.File
+	size    int64
+	day     string
+}
+
+func NewFilePersistor(file string, maxSize int64, daily bool) *FilePersistor {
+	persistor := new(FilePersistor)
+	persistor.File = file
+	persistor.MaxSize = maxSize
+	persistor.Daily = daily
+	return persistor
+}
+
+func (persistor *FilePersistor) Open() error {
+	persistor.mutex.Lock()
+	defer persistor.mutex.Unlock()
+	return persistor.open()
+}
+
+func (persistor *FilePersistor) open() error {
+
+	file, err := os.OpenFile(persistor.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	persistor.file = file
+	persistor.size = info.Size()
+	persistor.day = info.ModTime().Format("20060102")
+	if persistor.size == 0 {
+		persistor.day = time.Now().Format("20060102")
+	}
+	return nil
+}
+
+func (persistor *FilePersistor) Persist(ivrChannel *IVRChannel) {
+	if err := persistor.WriteRecords([]*CallRecord{ivrChannel.finishRecord()}); err != nil {
+		ivrChannel.Log.Error("Persist call record failure for %s", err.Error())
+	}
+}
+
+func (persistor *FilePersistor) WriteRecords(records []*CallRecord) error {
+
+	persistor.mutex.Lock()
+	defer persistor.mutex.Unlock()
+
+	if persistor.file == nil {
+		if err := persistor.open(); err != nil {
+			return err
+		}
+	}
+
+	if err := persistor.rotate(); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		n, err := persistor.file.Write(line)
+		persistor.size = persistor.size + int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (persistor *FilePersistor) rotate() error {
+
+	now := time.Now()
+	day := now.Format("20060102")
+	if persistor.size == 0 {
+		persistor.day = day
+		return nil
+	}
+
+	sizeFull := persistor.MaxSize > 0 && persistor.size >= persistor.MaxSize
+	dayPassed := persistor.Daily && day != persistor.day
+	if !sizeFull && !dayPassed {
+		return nil
+	}
+
+	persistor.file.Close()
+	persistor.file = nil
+
+	rotated := persistor.File + "." + now.Format("20060102-150405")
+	if _, err := os.Stat(rotated); err == nil {
+		rotated = persistor.File + "." + now.Format("20060102-150405.000000000")
+	}
+	if err := os.Rename(persistor.File, rotated); err != nil {
+		return err
+	}
+
+	return persistor.open()
+}
+
+func (persistor *FilePersistor) Close() {
+	persistor.mutex.Lock()
+	defer persistor.mutex.Unlock()
+	if persistor.file != nil {
+		persistor.file.Close()
+		persistor.file = nil
+	}
+}
@@ -0,0 +1,38 @@
+// FilePersistor test
+
+package ivr
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilePersistorRotate(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "ivr_cdr.jsonl")
+	persistor := NewFilePersistor(file, 1, false)
+	if err := persistor.Open(); err != nil {
+		t.Fatalf("Open failure for %s", err.Error())
+	}
+	defer persistor.Close()
+
+	for _, callId := range []string{"call-1", "call-2"} {
+		record := NewCallRecord(time.Now())
+		record.CallId = callId
+		if err := persistor.WriteRecords([]*CallRecord{record}); err != nil {
+			t.Fatalf("WriteRecords failure for %s", err.Error())
+		}
+	}
+
+	records, err := readRecordFile(file)
+	if err != nil || len(records) != 1 || records[0].CallId != "call-2" {
+		t.Fatalf("Current file records=%d,err=%v,expect call-2 only", len(records), err)
+	}
+
+	rotated, _ := filepath.Glob(file + ".*")
+	if len(rotated) != 1 {
+		t.Fatalf("Rotated files=%d,expect 1", len(rotated))
+	}
+	t.Log("Test pass.")
+}
@@ -0,0 +1,283 @@
+// fs/ivr/ HTTPRequestNode
+
+package ivr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fs/ivr/calllog"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const Default_HTTP_Timeout int = 5000
+const Max_HTTP_Response int64 = 1 << 20
+
+type HTTPHeader struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type HTTPHeaders struct {
+	Header []HTTPHeader
+}
+
+// HTTPField is a field of the JSON body,taken from call variable Var,from
+// expression Expr (numbers and booleans keep their type) or from Value.
+type HTTPField struct {
+	Name  string `xml:"name,attr"`
+	Var   string `xml:"var,attr"`
+	Expr  string `xml:"expr,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type HTTPBody struct {
+	Field []HTTPField
+}
+
+// HTTPResult copies the value at Path of the JSON response to call variable Var.
+// Path is dot separated,array items are indexed by number,eg. accounts.0.balance
+type HTTPResult struct {
+	Path string `xml:"path,attr"`
+	Var  string `xml:"var,attr"`
+}
+
+type HTTPResults struct {
+	Result []HTTPResult
+}
+
+type HTTPStatus struct {
+	Code     int    `xml:"code,attr"`
+	NextNode string `xml:"nextNode,attr"`
+}
+
+type HTTPStatuses struct {
+	Status []HTTPStatus
+}
+
+// HTTPRequestNode calls a backend with a JSON body built from call variables
+// and maps the JSON response back to call variables.The next node is the
+// Status of the response code,else OnSuccess for 2xx or OnFailure.OnTimeout
+// and OnError default to OnError and OnFailure.The response code is kept in
+// call variable http_status.
+type HTTPRequestNode struct {
+	NodeName  string `xml:"name,attr"`
+	Method    string // Default GET,or POST with a body.
+	Url       string // ${name} is replaced with the escaped variable.
+	Headers   HTTPHeaders
+	Body      HTTPBody
+	Timeout   int // Millisecond.
+	Results   HTTPResults
+	Statuses  HTTPStatuses
+	OnSuccess string
+	OnFailure string
+	OnTimeout string
+	OnError   string
+}
+
+func (node HTTPRequestNode) method() string {
+	if node.Method != "" {
+		return strings.ToUpper(node.Method)
+	}
+	if len(node.Body.Field) > 0 {
+		return http.MethodPost
+	}
+	return http.MethodGet
+}
+
+func (node HTTPRequestNode) body(vars *CallVars) (io.Reader, error) {
+	if len(node.Body.Field) == 0 {
+		return nil, nil
+	}
+	fields := make(map[string]interface{}, len(node.Body.Field))
+	for _, field := range node.Body.Field {
+		switch {
+		case field.Var != "":
+			fields[field.Name] = vars.Get(field.Var)
+		case field.Expr != "":
+			value, err := EvalExpression(field.Expr, vars)
+			if err != nil {
+				return nil, err
+			}
+			fields[field.Name] = value
+		default:
+			fields[field.Name] = vars.Expand(field.Value)
+		}
+	}
+	content, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+func (node HTTPRequestNode) onError() string {
+	if node.OnError != "" {
+		return node.OnError
+	}
+	return node.OnFailure
+}
+
+func (node HTTPRequestNode) onTimeout() string {
+	if node.OnTimeout != "" {
+		return node.OnTimeout
+	}
+	return node.onError()
+}
+
+func (node HTTPRequestNode) onStatus(code int) string {
+	for _, status := range node.Statuses.Status {
+		if status.Code == code {
+			return status.NextNode
+		}
+	}
+	if code >= 200 && code < 300 {
+		return node.OnSuccess
+	}
+	return node.OnFailure
+}
+
+// logURL returns u without user info and query for logs,the query may hold
+// call variables like ?pin=${DtmfValue}.
+func logURL(u *url.URL) string {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// request calls the backend and returns the next node.
+func (node HTTPRequestNode) request(vars *CallVars, log *calllog.Logger) string {
+
+	timeoutMs := node.Timeout
+	if timeoutMs <= 0 {
+		timeoutMs = Default_HTTP_Timeout
+	}
+
+	body, err := node.body(vars)
+	if err != nil {
+		log.Warn("Build http body failure for %s", err.Error())
+		return node.onError()
+	}
+
+	method := node.method()
+	requestUrl := vars.ExpandURL(node.Url)
+	request, err := http.NewRequest(method, requestUrl, body)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		log.Warn("Build http request failure for %s", err.Error())
+		return node.onError()
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	request.Header.Set("Accept", "application/json")
+	for _, header := range node.Headers.Header {
+		request.Header.Set(header.Name, vars.Expand(header.Value))
+	}
+
+	client := &http.Client{Timeout: time.Duration(timeoutMs) * time.Millisecond}
+	start := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			log.Warn("Http %s %s timeout after %dms", method, logURL(request.URL), timeoutMs)
+			return node.onTimeout()
+		}
+		// The error of client.Do holds the whole url.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		log.Warn("Http %s %s failure for %s", method, logURL(request.URL), err.Error())
+		return node.onError()
+	}
+	defer response.Body.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, Max_HTTP_Response))
+	if err != nil {
+		log.Warn("Read http response failure for %s", err.Error())
+		return node.onError()
+	}
+	vars.Set("http_status", strconv.Itoa(response.StatusCode))
+	log.Info("Http %s %s status=%d in %dms", method, logURL(request.URL), response.StatusCode, time.Since(start)/time.Millisecond)
+
+	if len(node.Results.Result) > 0 && len(bytes.TrimSpace(content)) > 0 {
+		var data interface{}
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		if err := decoder.Decode(&data); err != nil {
+			log.Warn("Decode http response failure for %s", err.Error())
+			return node.onError()
+		}
+		for _, result := range node.Results.Result {
+			if value, ok := jsonPath(data, result.Path); ok {
+				vars.Set(result.Var, jsonString(value))
+			} else {
+				log.Debug("Path %s not find in http response", result.Path)
+			}
+		}
+	}
+
+	return node.onStatus(response.StatusCode)
+}
+
+// jsonPath returns the value at the dot separated path of data.
+func jsonPath(data interface{}, path string) (interface{}, bool) {
+	if path == "" || path == "." {
+		return data, true
+	}
+	for _, key := range strings.Split(strings.Replace(strings.Replace(path, "[", ".", -1), "]", "", -1), ".") {
+		if key == "" {
+			continue
+		}
+		switch value := data.(type) {
+		case map[string]interface{}:
+			item, ok := value[key]
+			if !ok {
+				return nil, false
+			}
+			data = item
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(value) {
+				return nil, false
+			}
+			data = value[index]
+		default:
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	content, _ := json.Marshal(value)
+	return string(content)
+}
+
+func (node HTTPRequestNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	return node.request(ivrChannel.Vars, ivrChannel.Log), nil
+}
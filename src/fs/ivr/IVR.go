@@ -8,16 +8,20 @@
 package ivr
 
 import (
-	l4g "code.google.com/p/log4go"
 	"errors"
+	"fs/ivr/calllog"
 	"fs/ivr/eventsocket"
 	"net"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var noMatchErr error = errors.New("NoMatch")
 var noInputErr error = errors.New("NoInput")
+var playCanceledErr error = errors.New("Playback canceled")
 
 const IVRChannel_State_Init string = "Init"
 const IVRChannel_State_Service string = "Service"
@@ -28,22 +32,40 @@ const Max_DTMF_Length int = 20
 var ivrNodeMap map[string]IVRNode = make(map[string]IVRNode)
 var ivrPromptMap map[string]Prompt = make(map[string]Prompt)
 var ivrGrammarMap map[string]Grammar = make(map[string]Grammar)
+var ivrQueryMap map[string]Query = make(map[string]Query)
+var ivrVars []Var
 
 type IVR struct {
-	channelMap       map[string]IVRChannel
+	channelMap       map[string]*IVRChannel
+	channelMutex     sync.Mutex
 	persistor        Persistor
+	limiter          *CallLimiter
 	confFileLoadTime time.Time
 }
 
 func NewIVR() *IVR {
 	ivr := new(IVR)
-	ivr.channelMap = make(map[string]IVRChannel)
+	ivr.channelMap = make(map[string]*IVRChannel)
+	ivr.limiter = NewCallLimiter(serverConfig.Limits)
 	return ivr
 }
 
+func (ivr *IVR) addChannel(ivrChannel *IVRChannel) {
+	ivr.channelMutex.Lock()
+	defer ivr.channelMutex.Unlock()
+	ivr.channelMap[ivrChannel.ChannelName] = ivrChannel
+}
+
+func (ivr *IVR) removeChannel(ivrChannel *IVRChannel) {
+	ivr.channelMutex.Lock()
+	defer ivr.channelMutex.Unlock()
+	delete(ivr.channelMap, ivrChannel.ChannelName)
+}
+
 type IVRChannel struct {
 	ChannelName    string
 	ChannelId      string
+	Flow           string
 	Dtmf           chan string
 	ChannelState   string
 	ChanCreateTime time.Time
@@ -52,35 +74,60 @@ type IVRChannel struct {
 	PlaybackDone   chan bool
 	NoMatchTimes   int
 	NoInputTimes   int
-	CallParams     map[string]string
+	Vars           *CallVars
 	ActiveNode     string
 	ChannelHangup  chan bool
+	Log            *calllog.Logger
+	DtmfSensitive  bool // DtmfValue is sensitive and must be masked out of the flow.
+	Record         *CallRecord
+	AppDone        chan *eventsocket.Event // CHANNEL_EXECUTE_COMPLETE of the application waited by executeWait.
+	CallStack      []CallFrame             // Sub flows waiting for their ReturnNode.
+	Speech         chan string             // Results of detect_speech.
+	sensitiveInput int32                   // Collecting sensitive digits,accessed atomically.
+	bridged        int32                   // CHANNEL_BRIDGE is received,accessed atomically.
+	cancelPlay     <-chan struct{}         // Closed to break the playback,eg. at the deadline of a script.
+	waitApp        string
+	waitMutex      sync.Mutex
+	stateMutex     sync.RWMutex // Guards ChannelState,ChannelId,ActiveNode and DtmfValue read by the admin api.
 }
 
 func NewIVRChannel(clientConn net.Conn) *IVRChannel {
 	ivrChannel := new(IVRChannel)
 	ivrChannel.ChannelName = clientConn.RemoteAddr().String()
 	ivrChannel.Esocket = eventsocket.NewESocket(clientConn, ivrChannel)
+	ivrChannel.Log = ivrChannel.Esocket.Log
 	ivrChannel.Dtmf = make(chan string, Max_DTMF_Length)
 	ivrChannel.ChanCreateTime = time.Now()
+	ivrChannel.Record = NewCallRecord(ivrChannel.ChanCreateTime)
 	ivrChannel.ChannelState = IVRChannel_State_Init
-	ivrChannel.PlaybackDone = make(chan bool, 0)
-	ivrChannel.CallParams = make(map[string]string)
+	ivrChannel.PlaybackDone = make(chan bool, 1)
+	ivrChannel.Vars = NewCallVars()
 	ivrChannel.ChannelHangup = make(chan bool)
+	ivrChannel.AppDone = make(chan *eventsocket.Event, 1)
+	ivrChannel.Speech = make(chan string, 1)
 	ivrChannel.NoInputTimes = 0
 	ivrChannel.NoMatchTimes = 0
 
 	ivrChannel.Esocket.Init()
 
-	connId, err := ivrChannel.Esocket.SendCmd("connect\n\n")
+	channelData, err := ivrChannel.Esocket.Connect()
 	if err != nil {
-		l4g.Error("Init IVRChannel failure for %s", err.Error())
+		ivrChannel.Log.Error("Init IVRChannel failure for %s", err.Error())
 		return nil
 	}
 
-	ivrChannel.ChannelId = connId
-	l4g.Debug("Update channel[%s] connId=%s", ivrChannel.ChannelName, ivrChannel.ChannelId)
-	ivrChannel.Esocket.SendCmd("event json PLAYBACK_START PLAYBACK_STOP DTMF CHANNEL_ANSWER\n\n")
+	ivrChannel.ChannelId = channelData.Header["Channel-Unique-Id"]
+	ivrChannel.Vars.SetChannelVars(channelData.Header)
+	ivrChannel.Vars.Set("ANI", channelData.Header["Caller-Caller-Id-Number"])
+	ivrChannel.Vars.Set("DNIS", channelData.Header["Caller-Destination-Number"])
+	ivrChannel.Vars.Set("ChannelId", ivrChannel.ChannelId)
+	ivrChannel.Log.Set(calllog.Field_Call, ivrChannel.ChannelId)
+	ivrChannel.Log.Set(calllog.Field_ANI, ivrChannel.Vars.Get("ANI"))
+	ivrChannel.Log.Set(calllog.Field_DNIS, ivrChannel.Vars.Get("DNIS"))
+	ivrChannel.Log.Debug("Update channel[%s] connId=%s", ivrChannel.ChannelName, ivrChannel.ChannelId)
+	// Events carry the channel variables only when verbose,eg. originate_disposition.
+	ivrChannel.Esocket.SetVar("verbose_events", "true")
+	ivrChannel.Esocket.SendCmd("event json PLAYBACK_START PLAYBACK_STOP DTMF CHANNEL_ANSWER CHANNEL_HANGUP CHANNEL_EXECUTE_COMPLETE CHANNEL_BRIDGE CHANNEL_UNBRIDGE DETECTED_SPEECH\n\n")
 
 	return ivrChannel
 }
@@ -88,31 +135,62 @@ func NewIVRChannel(clientConn net.Conn) *IVRChannel {
 func (channel *IVRChannel) OnEvent(event *eventsocket.Event) {
 
 	if event != nil {
-		l4g.Debug("------------------------> New Event eventName=%s,callId=%s", event.Header["Event-Name"], event.Header["Channel-Call-UUID"])
-		if event.Header["Channel-Call-UUID"] == channel.ChannelId {
+		channel.Log.Debug("------------------------> New Event eventName=%s,callId=%s", event.Header["Event-Name"], event.Header["Channel-Call-UUID"])
+		// Events of the other leg of a bridge share the Channel-Call-UUID.
+		if event.Header["Unique-ID"] == channel.ChannelId {
 			if eventName, ok := event.Header["Event-Name"]; ok {
-				l4g.Trace("IVR onEvent ----->  %s", eventName)
+				channel.Log.Trace("IVR onEvent ----->  %s", eventName)
 				if "DTMF" == eventName {
 					dtmf, _ := event.Header["DTMF-Digit"]
-					l4g.Trace("Rec new dtmf value -> %s", dtmf)
-					channel.Dtmf <- dtmf
+					channel.Log.Trace("Rec new dtmf value -> %s", channel.maskInput(dtmf))
+					select {
+					case channel.Dtmf <- dtmf:
+					default:
+						channel.Log.Warn("Drop dtmf,%d digits are waiting", len(channel.Dtmf))
+					}
 				}
-				if "PLAYBACK_STOP" == eventName {
-					if playStatus := event.Header["Playback-Status"]; "break" == playStatus {
-						channel.PlaybackDone <- true
-					} else {
-						channel.PlaybackDone <- false
+				// Files played by an application waited by executeWait,eg. say,are not prompts.
+				if "PLAYBACK_STOP" == eventName && !channel.executing() {
+					select {
+					case channel.PlaybackDone <- "break" == event.Header["Playback-Status"]:
+					default:
+						channel.Log.Warn("Drop playback stop of %s", event.Header["Playback-File-Path"])
 					}
 				}
 
 				if "CHANNEL_ANSWER" == eventName {
-					channel.ChannelState = IVRChannel_State_Service
-					channel.CallParams["ANI"] = event.Header["Caller-Orig-Caller-ID-Number"]
-					channel.CallParams["DNIS"] = event.Header["Caller-Destination-Number"]
-					channel.CallParams["callId"] = event.Header["Channel-Call-UUID"]
-					channel.CallParams["connId"] = event.Header["Unique-ID"]
-					channel.ChannelId = event.Header["Channel-Call-UUID"]
-					l4g.Trace("Show CallInfo ani=%s,dnis=%s,callId=%s,connId=%s", channel.CallParams["ANI"], channel.CallParams["DNIS"], channel.CallParams["callId"], channel.CallParams["connId"])
+					channel.setChannelState(IVRChannel_State_Service)
+					channel.Record.Answer(time.Now())
+					channel.Vars.Set("ANI", event.Header["Caller-Orig-Caller-ID-Number"])
+					channel.Vars.Set("DNIS", event.Header["Caller-Destination-Number"])
+					channel.Vars.Set("callId", event.Header["Channel-Call-UUID"])
+					channel.Vars.Set("connId", event.Header["Unique-ID"])
+					channel.setChannelId(event.Header["Unique-ID"])
+					channel.Log.Set(calllog.Field_Call, channel.ChannelId)
+					channel.Log.Set(calllog.Field_ANI, channel.Vars.Get("ANI"))
+					channel.Log.Trace("Show CallInfo ani=%s,dnis=%s,callId=%s,connId=%s", channel.Vars.Get("ANI"), channel.Vars.Get("DNIS"), channel.Vars.Get("callId"), channel.Vars.Get("connId"))
+				}
+
+				if "CHANNEL_EXECUTE_COMPLETE" == eventName {
+					channel.onExecuteComplete(event)
+				}
+
+				if "DETECTED_SPEECH" == eventName {
+					channel.onDetectedSpeech(event)
+				}
+
+				if "CHANNEL_BRIDGE" == eventName {
+					channel.setBridged(true)
+					channel.Log.Info("Channel bridged to %s", event.Header["Other-Leg-Unique-ID"])
+				}
+
+				if "CHANNEL_UNBRIDGE" == eventName {
+					channel.Log.Info("Channel unbridged from %s", event.Header["Other-Leg-Unique-ID"])
+				}
+
+				if "CHANNEL_HANGUP" == eventName {
+					channel.Record.Hangup(event.Header["Hangup-Cause"])
+					channel.Log.Trace("Channel hangup cause=%s", event.Header["Hangup-Cause"])
 				}
 
 			}
@@ -121,13 +199,218 @@ func (channel *IVRChannel) OnEvent(event *eventsocket.Event) {
 		if "HANGUP" == event.Header["Event-Name"] {
 			channel.Esocket.Running = false
 			channel.Esocket.Close()
-			channel.ChannelState = IVRChannel_State_Hangup
+			channel.setChannelState(IVRChannel_State_Hangup)
 			channel.ChannelHangup <- true // Channel hangup.
-			l4g.Info("Rec client disconnected event and close channel.")
+			channel.Log.Info("Rec client disconnected event and close channel.")
+		}
+	}
+}
+
+// executeWait executes app and waits until FreeSWITCH completes it,timeoutMs <= 0
+// waits without limit.It returns the CHANNEL_EXECUTE_COMPLETE event of app.
+func (ivrChannel *IVRChannel) executeWait(app, arg string, timeoutMs int) (*eventsocket.Event, error) {
+
+	ivrChannel.waitMutex.Lock()
+	ivrChannel.waitApp = app
+	// Drop a completion left by a former wait.
+	for len(ivrChannel.AppDone) > 0 {
+		<-ivrChannel.AppDone
+	}
+	ivrChannel.waitMutex.Unlock()
+
+	// A late CHANNEL_EXECUTE_COMPLETE must not match the next wait.
+	defer func() {
+		ivrChannel.waitMutex.Lock()
+		ivrChannel.waitApp = ""
+		ivrChannel.waitMutex.Unlock()
+	}()
+
+	if _, err := ivrChannel.Esocket.Execute(app, arg); err != nil {
+		return nil, err
+	}
+
+	var timeout chan bool
+	if timeoutMs > 0 {
+		timeout = eventsocket.CheckTimeout(timeoutMs)
+	}
+
+	select {
+	case event := <-ivrChannel.AppDone:
+		return event, nil
+	case <-timeout:
+		return nil, errors.New("Timeout : " + app)
+	case <-ivrChannel.ChannelHangup:
+		return nil, errors.New("Channel hangup.")
+	case <-ivrChannel.cancelPlay:
+		ivrChannel.breakPlayback()
+		select {
+		case <-ivrChannel.AppDone:
+		case <-ivrChannel.ChannelHangup:
+			return nil, errors.New("Channel hangup.")
+		}
+		return nil, playCanceledErr
+	}
+}
+
+// channelVars adds the variables names of the channel to event when it is not
+// verbose,they are read by uuid_getvar.
+func (ivrChannel *IVRChannel) channelVars(event *eventsocket.Event, names ...string) {
+
+	for name := range event.Header {
+		if strings.HasPrefix(name, Channel_Var_Prefix) {
+			return
+		}
+	}
+
+	for _, name := range names {
+		value, err := ivrChannel.Esocket.Api("uuid_getvar " + ivrChannel.ChannelId + " " + name)
+		if err != nil {
+			ivrChannel.Log.Warn("Get channel variable %s failure for %s", name, err.Error())
+			continue
+		}
+		if value != "_undef_" {
+			event.Header[Channel_Var_Prefix+name] = value
+		}
+	}
+}
+
+// breakPlayback stops the application running on the channel,an execute
+// command would wait in the queue behind it.
+func (ivrChannel *IVRChannel) breakPlayback() {
+	if _, err := ivrChannel.Esocket.SendCmd("bgapi uuid_break " + ivrChannel.ChannelId + " all"); err != nil {
+		ivrChannel.Log.Warn("Break playback failure for %s", err.Error())
+	}
+}
+
+// playback plays file (a full path or stream) and waits for PLAYBACK_STOP,
+// it returns true when the caller breaks the playback.
+func (ivrChannel *IVRChannel) playback(file string) (bool, error) {
+
+	// Drop the stop of a playback which was not waited.
+	for len(ivrChannel.PlaybackDone) > 0 {
+		<-ivrChannel.PlaybackDone
+	}
+
+	if _, err := ivrChannel.Esocket.Execute("playback", file); err != nil {
+		return false, err
+	}
+
+	select {
+	case done := <-ivrChannel.PlaybackDone:
+		return done, nil
+	case <-ivrChannel.ChannelHangup:
+		return false, errors.New("Channel hangup.")
+	case <-ivrChannel.cancelPlay:
+		// Wait for PLAYBACK_STOP of the break,it must not end the next playback.
+		ivrChannel.breakPlayback()
+		select {
+		case <-ivrChannel.PlaybackDone:
+		case <-ivrChannel.ChannelHangup:
+			return false, errors.New("Channel hangup.")
+		}
+		return false, playCanceledErr
+	}
+}
+
+func (channel *IVRChannel) executing() bool {
+	channel.waitMutex.Lock()
+	defer channel.waitMutex.Unlock()
+	return channel.waitApp != ""
+}
+
+func (channel *IVRChannel) onExecuteComplete(event *eventsocket.Event) {
+
+	channel.waitMutex.Lock()
+	defer channel.waitMutex.Unlock()
+
+	if channel.waitApp == "" || channel.waitApp != event.Header["Application"] {
+		return
+	}
+	channel.waitApp = ""
+	select {
+	case channel.AppDone <- event:
+	default:
+		channel.Log.Warn("Drop execute complete event of %s", event.Header["Application"])
+	}
+}
+
+// setVars sets the call variables of assignments name=value separated by ';'.
+func (ivrChannel *IVRChannel) setVars(assignments string) {
+	for _, assignment := range strings.Split(assignments, ";") {
+		if pair := strings.SplitN(assignment, "=", 2); len(pair) == 2 {
+			name := strings.TrimSpace(pair[0])
+			value := ivrChannel.Vars.Expand(strings.TrimSpace(pair[1]))
+			ivrChannel.Vars.Set(name, value)
+			ivrChannel.Log.Debug("Set call variable %s=%s", name, ivrChannel.Esocket.Mask(value))
 		}
 	}
 }
 
+// SetActiveNode marks the node the call is in,nodes call it first.
+func (ivrChannel *IVRChannel) SetActiveNode(nodeName string) {
+	ivrChannel.stateMutex.Lock()
+	ivrChannel.ActiveNode = nodeName
+	ivrChannel.stateMutex.Unlock()
+	ivrChannel.Log.Set(calllog.Field_Node, nodeName)
+}
+
+func (ivrChannel *IVRChannel) setChannelState(state string) {
+	ivrChannel.stateMutex.Lock()
+	defer ivrChannel.stateMutex.Unlock()
+	ivrChannel.ChannelState = state
+}
+
+func (ivrChannel *IVRChannel) setChannelId(channelId string) {
+	ivrChannel.stateMutex.Lock()
+	defer ivrChannel.stateMutex.Unlock()
+	ivrChannel.ChannelId = channelId
+}
+
+// setDtmfValue keeps the collected digits in DtmfValue and the call variable.
+func (ivrChannel *IVRChannel) setDtmfValue(dtmfValue string, sensitive bool) {
+	ivrChannel.stateMutex.Lock()
+	ivrChannel.DtmfValue = dtmfValue
+	ivrChannel.DtmfSensitive = sensitive
+	ivrChannel.stateMutex.Unlock()
+	ivrChannel.Vars.Set("DtmfValue", dtmfValue)
+}
+
+func (ivrChannel *IVRChannel) setSensitiveInput(sensitive bool) {
+	if sensitive {
+		atomic.StoreInt32(&ivrChannel.sensitiveInput, 1)
+	} else {
+		atomic.StoreInt32(&ivrChannel.sensitiveInput, 0)
+	}
+}
+
+func (ivrChannel *IVRChannel) setBridged(bridged bool) {
+	if bridged {
+		atomic.StoreInt32(&ivrChannel.bridged, 1)
+	} else {
+		atomic.StoreInt32(&ivrChannel.bridged, 0)
+	}
+}
+
+func (ivrChannel *IVRChannel) isBridged() bool {
+	return atomic.LoadInt32(&ivrChannel.bridged) == 1
+}
+
+// maskInput masks value when sensitive digits are being collected.
+func (ivrChannel *IVRChannel) maskInput(value string) string {
+	if atomic.LoadInt32(&ivrChannel.sensitiveInput) == 1 {
+		return calllog.Mask(value)
+	}
+	return value
+}
+
+// MaskedDtmfValue returns DtmfValue for logs,records and the admin api.
+func (ivrChannel *IVRChannel) MaskedDtmfValue() string {
+	if ivrChannel.DtmfSensitive {
+		return calllog.Mask(ivrChannel.DtmfValue)
+	}
+	return ivrChannel.DtmfValue
+}
+
 type IVRNode interface {
 	Execute(ivrChannel *IVRChannel) (string, error)
 }
@@ -142,22 +425,20 @@ func executePrompt(prompts []string, ivrChannel *IVRChannel) {
 
 	if len(prompts) > 0 {
 		for _, promptName := range prompts {
+			promptName = ivrChannel.Vars.Expand(promptName)
 			// Find prompt from ivrPromptMap by promptName
 			if prompt, ok := ivrPromptMap[promptName]; ok {
-				if prompt.BargeIn {
-					ivrChannel.Esocket.BargeIn(true)
-				} else {
-					ivrChannel.Esocket.BargeIn(false)
+				done, err := prompt.play(ivrChannel)
+				if err != nil {
+					ivrChannel.Log.Warn("Play prompt %s failure for %s", promptName, err.Error())
+					return
 				}
-				ivrChannel.Esocket.PlayAnn(prompt.Phrase[0], prompt.PName, ivrChannel.ChannelId)
-
-				done := <-ivrChannel.PlaybackDone
-				l4g.Debug("ExecutePrompt done =%t", done)
+				ivrChannel.Log.Debug("ExecutePrompt done =%t", done)
 				if done {
 					break
 				}
 			} else {
-				l4g.Warn("Prompt not find for promptName=%s", promptName)
+				ivrChannel.Log.Warn("Prompt not find for promptName=%s", promptName)
 			}
 		}
 	}
@@ -170,7 +451,7 @@ func (node AnnNode) Execute(ivrChannel *IVRChannel) (string, error) {
 		return "", errors.New("channel state is invalid : hangup")
 	}
 
-	ivrChannel.ActiveNode = node.NodeName
+	ivrChannel.SetActiveNode(node.NodeName)
 
 	executePrompt(node.Prompts.Prompt, ivrChannel)
 
@@ -192,7 +473,7 @@ func (node MenuNode) Execute(ivrChannel *IVRChannel) (string, error) {
 		return "", errors.New("channel state is invalid : hangup")
 	}
 
-	ivrChannel.ActiveNode = node.NodeName
+	ivrChannel.SetActiveNode(node.NodeName)
 	// Clear dtmf channel value.
 	for len(ivrChannel.Dtmf) > 0 {
 		<-ivrChannel.Dtmf
@@ -208,7 +489,7 @@ func (node MenuNode) Execute(ivrChannel *IVRChannel) (string, error) {
 					executePrompt(prompt, ivrChannel)
 					<-ivrChannel.PlaybackDone
 				} else {
-					l4g.Warn("Prompt not find for promptName=%s", promptName)
+					ivrChannel.Log.Warn("Prompt not find for promptName=%s", promptName)
 				}
 			}
 		}
@@ -221,22 +502,26 @@ func (node MenuNode) Execute(ivrChannel *IVRChannel) (string, error) {
 	timeout := eventsocket.CheckTimeout(node.Timeout)
 	select {
 	case <-timeout:
-		l4g.Warn("Timeout,no dtmf.")
+		ivrChannel.Log.Warn("Timeout,no dtmf.")
 		ivrChannel.NoInputTimes = ivrChannel.NoInputTimes + 1
+		ivrChannel.recordInput("", false, Input_NoInput)
 		return node.NoInput, nil
 	case dtmf := <-ivrChannel.Dtmf:
 
 		for _, choice := range node.Choices.Choice {
 			if dtmf == choice.DTMF {
+				ivrChannel.recordInput(dtmf, false, Input_Match)
+				ivrChannel.setVars(choice.Set)
 				return choice.NextNode, nil
 			}
 		}
 
-		l4g.Warn("No match for dtmf=%s", dtmf)
+		ivrChannel.Log.Warn("No match for dtmf=%s", dtmf)
 		ivrChannel.NoMatchTimes = ivrChannel.NoMatchTimes + 1
+		ivrChannel.recordInput(dtmf, false, Input_NoMatch)
 		return node.NoMatch, nil
 	case <-ivrChannel.ChannelHangup:
-		l4g.Trace("Channel hangup.")
+		ivrChannel.Log.Trace("Channel hangup.")
 		return "", errors.New("Channel hangup.")
 	}
 
@@ -271,7 +556,7 @@ func (node GotoNode) Execute(ivrChannel *IVRChannel) (string, error) {
 					executePrompt(prompt, ivrChannel)
 					<-ivrChannel.PlaybackDone
 				} else {
-					l4g.Warn("Prompt not find for promptName=%s", promptName)
+					ivrChannel.Log.Warn("Prompt not find for promptName=%s", promptName)
 				}
 			}
 		}
@@ -299,7 +584,7 @@ func (node RootNode) Execute(ivrChannel *IVRChannel) (string, error) {
 	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
 		return "", errors.New("channel state is invalid : hangup")
 	}
-	ivrChannel.ActiveNode = node.NodeName
+	ivrChannel.SetActiveNode(node.NodeName)
 	ivrChannel.Esocket.AnswerCall()
 	time.Sleep(1000 * time.Millisecond)
 	return node.NextNode, nil
@@ -314,18 +599,21 @@ func (node ExitNode) Execute(ivrChannel *IVRChannel) (string, error) {
 	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
 		return "", errors.New("channel state is invalid : hangup")
 	}
-	ivrChannel.ActiveNode = node.NodeName
+	ivrChannel.SetActiveNode(node.NodeName)
+	ivrChannel.Record.SetOutcome(Outcome_SelfServed)
+	ivrChannel.Record.Hangup("NORMAL_CLEARING")
 	ivrChannel.Esocket.Hangup()
 	return "", nil
 }
 
 type PromptCollectNode struct {
-	NodeName string `xml:"name,attr"`
-	Prompts  PromptEntity
-	Grammars GrammarEntity
-	NoInput  string
-	NoMatch  string
-	NextNode string
+	NodeName  string `xml:"name,attr"`
+	Prompts   PromptEntity
+	Grammars  GrammarEntity
+	NoInput   string
+	NoMatch   string
+	NextNode  string
+	Sensitive bool
 }
 
 func (node PromptCollectNode) Execute(ivrChannel *IVRChannel) (string, error) {
@@ -334,7 +622,13 @@ func (node PromptCollectNode) Execute(ivrChannel *IVRChannel) (string, error) {
 		return "", errors.New("channel state is invalid : hangup")
 	}
 
-	ivrChannel.ActiveNode = node.NodeName
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	// Digits typed while the prompt is playing are sensitive too.
+	sensitive := node.Sensitive || ivrGrammarMap[node.Grammars.Grammar[0]].Sensitive
+	ivrChannel.setSensitiveInput(sensitive)
+	defer ivrChannel.setSensitiveInput(false)
+
 	// Clear dtmf channel value.
 	for len(ivrChannel.Dtmf) > 0 {
 		<-ivrChannel.Dtmf
@@ -350,7 +644,7 @@ func (node PromptCollectNode) Execute(ivrChannel *IVRChannel) (string, error) {
 					executePrompt(prompt, ivrChannel)
 					<-ivrChannel.PlaybackDone
 				} else {
-					l4g.Warn("Prompt not find for promptName=%s", promptName)
+					ivrChannel.Log.Warn("Prompt not find for promptName=%s", promptName)
 				}
 			}
 		}
@@ -371,7 +665,7 @@ func (node PromptCollectNode) Execute(ivrChannel *IVRChannel) (string, error) {
 			timeout := eventsocket.CheckTimeout(grammar.Timeout)
 			select {
 			case <-timeout:
-				l4g.Warn("Timeout,no dtmf.")
+				ivrChannel.Log.Warn("Timeout,no dtmf.")
 				done = true
 			case dtmf := <-ivrChannel.Dtmf:
 				if dtmf == grammar.Terminator {
@@ -383,44 +677,54 @@ func (node PromptCollectNode) Execute(ivrChannel *IVRChannel) (string, error) {
 					}
 				}
 			case <-ivrChannel.ChannelHangup:
-				l4g.Trace("Channel hangup.")
+				ivrChannel.Log.Trace("Channel hangup.")
 				return "", errors.New("Channel hangup.")
 			}
 		}
 
-		l4g.Debug("Now dtmf vlaue=%s", dtmfValue)
+		if sensitive {
+			ivrChannel.Esocket.AddSecret(dtmfValue)
+		}
+		ivrChannel.Log.Debug("Now dtmf vlaue=%s", ivrChannel.maskInput(dtmfValue))
 		if len(dtmfValue) == 0 {
 			// Timeout Noinput error.
 			ivrChannel.NoInputTimes = ivrChannel.NoInputTimes + 1
+			ivrChannel.recordInput("", sensitive, Input_NoInput)
 			return node.NoInput, nil
 		} else {
-			dtmfRex := regexp.MustCompile(grammar.Express)
-			if dtmfRex.MatchString(dtmfValue) {
-				ivrChannel.DtmfValue = dtmfValue
-				l4g.Trace("Collect dtmfValue=%s,nextNode=%s", ivrChannel.DtmfValue, node.NextNode)
+			dtmfRex, err := regexp.Compile(ivrChannel.Vars.ExpandRegexp(grammar.Express))
+			if err != nil {
+				ivrChannel.Log.Warn("Grammar %s express is invalid for %s", grammar.GName, err.Error())
+			}
+			if err == nil && dtmfRex.MatchString(dtmfValue) {
+				ivrChannel.setDtmfValue(dtmfValue, sensitive)
+				ivrChannel.Log.Trace("Collect dtmfValue=%s,nextNode=%s", ivrChannel.MaskedDtmfValue(), node.NextNode)
+				ivrChannel.recordInput(dtmfValue, sensitive, Input_Match)
 				return node.NextNode, nil
 			} else {
 				// No match. NoMath error.
 				ivrChannel.NoMatchTimes = ivrChannel.NoMatchTimes + 1
+				ivrChannel.recordInput(dtmfValue, sensitive, Input_NoMatch)
 				return node.NoMatch, nil
 			}
 		}
 
 	} else {
-		l4g.Warn("Grammar not find for %s at node %s", node.Grammars.Grammar[0], node.NodeName)
+		ivrChannel.Log.Warn("Grammar not find for %s at node %s", node.Grammars.Grammar[0], node.NodeName)
 		return "", errors.New("Grammar not find")
 	}
 
 }
 
 func (ivr *IVR) ExecuteCallFlow(nodeId string, ivrChannel *IVRChannel) {
-	l4g.Debug("Execute Node[%s] ... ", nodeId)
+	ivrChannel.Log.Debug("Execute Node[%s] ... ", nodeId)
 	if nodeId != "" && len(nodeId) > 0 {
 		if node, ok := ivrNodeMap[nodeId]; ok {
+			ivrChannel.Record.EnterNode(nodeId)
 			nodeId, err := node.Execute(ivrChannel)
-			// ivr.persistor.Persist(ivrChannel) // Persistor IVR data.
 			if err != nil {
-				l4g.Error("Execute Node failure for :%s", err.Error())
+				ivrChannel.Record.LeaveNode(err.Error())
+				ivrChannel.Log.Error("Execute Node failure for :%s", err.Error())
 
 				if err == noInputErr {
 					ivr.ExecuteCallFlow("NoInput", ivrChannel)
@@ -430,13 +734,14 @@ func (ivr *IVR) ExecuteCallFlow(nodeId string, ivrChannel *IVRChannel) {
 				return
 			}
 
+			ivrChannel.Record.LeaveNode(nodeId)
 			if nodeId != "" && len(nodeId) > 0 {
 				ivr.ExecuteCallFlow(nodeId, ivrChannel)
 			} else {
-				l4g.Info("CallFlow end...")
+				ivrChannel.Log.Info("CallFlow end...")
 			}
 		} else {
-			l4g.Error("NodeId not find for %s", nodeId)
+			ivrChannel.Log.Error("NodeId not find for %s", nodeId)
 		}
 	}
 }
@@ -11,52 +11,146 @@ import (
 	l4g "code.google.com/p/log4go"
 	"fmt"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 const Ivr_Config_File string = "/home/Admin/Dev/Go/work/FS_IVR/src/ivr.xml"
 
 var ivr *IVR = nil
 
+// activeCalls are the calls in progress,their records are persisted when they end.
+var activeCalls sync.WaitGroup
+
 func InitIVRServer(port int) {
 
 	serverPort := fmt.Sprintf(":%d", port)
 	listener, err := net.Listen("tcp", serverPort)
 	if err != nil {
 		l4g.Error("Listening on tcp port:%d failure for %s,system will exit.", port, err.Error())
+		return
 	}
 
 	ivr = NewIVR()
 
-	dbPersistor := NewDBPersistor("mysql", "172.16.0.154:3306", "root", "root01", "ivr")
-	dbPersistor.Open()
+	persistor, err := NewPersistor(serverConfig.Persistence)
+	if err != nil {
+		l4g.Error("Create persistor failure for %s,call records are discarded.", err.Error())
+		persistor = new(NoopPersistor)
+	}
+	if err = persistor.Open(); err == incompatibleSchemaErr {
+		l4g.Error("Refuse to run against an incompatible database schema,system will exit.")
+		return
+	}
 
-	ivr.persistor = dbPersistor
+	ivr.persistor = persistor
+
+	if serverConfig.QueryDB.Type != "" {
+		if queryDB, err = OpenQueryDB(serverConfig.QueryDB); err != nil {
+			l4g.Error("Open query database failure for %s,DBQueryNode fails until it is up.", err.Error())
+		}
+	}
 
 	l4g.Info("IVRSever listening TCP :%d", port)
 
+	if serverConfig.AdminPort > 0 {
+		go InitAdminServer(serverConfig.AdminPort)
+	}
+
+	// Stop accepting calls on SIGINT/SIGTERM and flush the queued call records.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		l4g.Info("Receive signal %s,IVRServer is shutting down.", sig.String())
+		listener.Close()
+	}()
+
 	for {
 		clientConn, err := listener.Accept()
 		if err != nil {
 			l4g.Warn("Accept client failure for : %s", err.Error())
 			break
 		}
-		go handleClient(clientConn)
+		activeCalls.Add(1)
+		go func() {
+			defer activeCalls.Done()
+			handleClient(clientConn)
+		}()
 	}
 
+	if !waitCalls(serverConfig.ShutdownTimeout) {
+		l4g.Warn("Calls are still in progress after %dms,their records are lost.", serverConfig.ShutdownTimeout)
+	}
+	persistor.Close()
+	l4g.Info("IVRServer stopped.")
+}
+
+// waitCalls waits at most timeoutMs for the calls in progress to end.
+func waitCalls(timeoutMs int) bool {
+	done := make(chan bool)
+	go func() {
+		activeCalls.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Duration(timeoutMs) * time.Millisecond):
+		return false
+	}
 }
 
 func handleClient(clientConn net.Conn) {
 
 	l4g.Trace("New client :%s", clientConn.RemoteAddr().String())
+	defer clientConn.Close()
 
 	ivrChannel := NewIVRChannel(clientConn)
-	ivr.channelMap[ivrChannel.ChannelName] = *ivrChannel
+	if ivrChannel == nil {
+		return
+	}
 
-	defer delete(ivr.channelMap, ivrChannel.ChannelName)
-	defer clientConn.Close()
+	LoadIVRConfig(serverConfig.FlowFile)
+	for _, v := range ivrVars {
+		ivrChannel.Vars.SetDefault(v.Name, ivrChannel.Vars.Expand(v.Value))
+	}
 
-	LoadIVRConfig(Ivr_Config_File)
+	flow := serverConfig.FindFlow(ivrChannel.Vars.Get("DNIS"))
+	ivrChannel.Flow = flow.Name
+	ivrChannel.Log.Set("flow", flow.Name)
+	if err := ivr.limiter.Acquire(flow, ivrChannel.Vars.Get("ANI")); err != nil {
+		ivrChannel.Log.Warn("Refuse call for %s", err.Error())
+		refuseCall(ivrChannel)
+		return
+	}
+	defer ivr.limiter.Release(flow)
+
+	ivr.addChannel(ivrChannel)
+	defer ivr.removeChannel(ivrChannel)
+	defer ivr.persistor.Persist(ivrChannel)
 
-	ivr.ExecuteCallFlow("root", ivrChannel)
+	ivr.ExecuteCallFlow(flow.Root, ivrChannel)
 
 }
+
+// refuseCall plays busy prompt and hangup,or gives the call back to the dialplan.
+func refuseCall(ivrChannel *IVRChannel) {
+
+	if serverConfig.Limits.OverloadAction == Overload_Action_Busy {
+		ivrChannel.Esocket.AnswerCall()
+		if serverConfig.Limits.BusyPrompt != "" {
+			executePrompt([]string{serverConfig.Limits.BusyPrompt}, ivrChannel)
+		}
+		ivrChannel.Esocket.Hangup()
+		return
+	}
+
+	if err := ivrChannel.Esocket.Exit(); err != nil {
+		ivrChannel.Log.Warn("Exit socket failure for %s", err.Error())
+	}
+}
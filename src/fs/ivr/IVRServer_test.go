@@ -0,0 +1,26 @@
+// IVRServer test
+
+package ivr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitCalls(t *testing.T) {
+
+	activeCalls.Add(1)
+	if waitCalls(50) {
+		t.Fatal("Wait with a call in progress,expect timeout")
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		activeCalls.Done()
+	}()
+	if !waitCalls(1000) {
+		t.Fatal("Wait for the call to end,expect done")
+	}
+
+	t.Log("Test pass.")
+}
@@ -0,0 +1,35 @@
+// fs/ivr/ Language
+
+package ivr
+
+// Language_Var is the call variable holding the language of the call,set it
+// with a menu choice,eg. <Choice name="english" dtmf="2" set="language=en" nextNode="mainMenu"/>
+const Language_Var string = "language"
+
+// Languages of the sound library,the files of a language are under
+// <Ivr_Sound_Path><lang>/.Without languages the library is flat as before.
+type Languages struct {
+	Default  string `xml:"default,attr"` // Default first language.
+	Language []string
+}
+
+// resolve returns lang if the library has it,or the default language.
+func (languages Languages) resolve(lang string) string {
+	if len(languages.Language) == 0 {
+		return ""
+	}
+	for _, language := range languages.Language {
+		if language == lang {
+			return lang
+		}
+	}
+	if languages.Default != "" {
+		return languages.Default
+	}
+	return languages.Language[0]
+}
+
+// language returns the library language of the call,empty for a flat library.
+func (ivrChannel *IVRChannel) language() string {
+	return serverConfig.Languages.resolve(ivrChannel.Vars.Get(Language_Var))
+}
@@ -0,0 +1,26 @@
+// Language test
+
+package ivr
+
+import (
+	"testing"
+)
+
+func TestResolveLanguage(t *testing.T) {
+
+	if lang := (Languages{}).resolve("en"); lang != "" {
+		t.Fatalf("Flat library language=%s", lang)
+	}
+
+	languages := Languages{Default: "zh", Language: []string{"zh", "en", "ja"}}
+	if lang := languages.resolve("en"); lang != "en" {
+		t.Fatalf("Language=%s,expect en", lang)
+	}
+	if lang := languages.resolve("fr"); lang != "zh" {
+		t.Fatalf("Language=%s,expect default zh", lang)
+	}
+	if lang := languages.resolve(""); lang != "zh" {
+		t.Fatalf("Language=%s,expect default zh", lang)
+	}
+	t.Log("Test pass.")
+}
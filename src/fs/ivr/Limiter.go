@@ -0,0 +1,113 @@
+// fs/ivr/ Limiter
+
+package ivr
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var overloadErr error = errors.New("Overload")
+var rateLimitErr error = errors.New("RateLimit")
+
+type CallLimiter struct {
+	mutex     sync.Mutex
+	limits    Limits
+	calls     int
+	flowCalls map[string]int
+	aniCalls  map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewCallLimiter(limits Limits) *CallLimiter {
+	limiter := new(CallLimiter)
+	limiter.limits = limits
+	limiter.flowCalls = make(map[string]int)
+	limiter.aniCalls = make(map[string][]time.Time)
+	limiter.lastSweep = time.Now()
+	return limiter
+}
+
+// Acquire reserves a call slot for flow,the slot must be returned by Release.
+func (limiter *CallLimiter) Acquire(flow *Flow, ani string) error {
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+
+	if limiter.limits.MaxCalls > 0 && limiter.calls >= limiter.limits.MaxCalls {
+		return overloadErr
+	}
+
+	if flow.MaxCalls > 0 && limiter.flowCalls[flow.Name] >= flow.MaxCalls {
+		return overloadErr
+	}
+
+	if limiter.limits.AniMaxCalls > 0 && ani != "" {
+		period := time.Duration(limiter.limits.AniPeriod) * time.Millisecond
+		limiter.sweep(now, period)
+
+		recent := pruneCallTimes(limiter.aniCalls[ani], now.Add(-period))
+		if len(recent) >= limiter.limits.AniMaxCalls {
+			limiter.aniCalls[ani] = recent
+			return rateLimitErr
+		}
+		limiter.aniCalls[ani] = append(recent, now)
+	}
+
+	limiter.calls = limiter.calls + 1
+	limiter.flowCalls[flow.Name] = limiter.flowCalls[flow.Name] + 1
+	return nil
+}
+
+func (limiter *CallLimiter) Release(flow *Flow) {
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if limiter.calls > 0 {
+		limiter.calls = limiter.calls - 1
+	}
+	if limiter.flowCalls[flow.Name] > 0 {
+		limiter.flowCalls[flow.Name] = limiter.flowCalls[flow.Name] - 1
+	}
+}
+
+// Calls returns the active call count,all flows when flowName is empty.
+func (limiter *CallLimiter) Calls(flowName string) int {
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if flowName == "" {
+		return limiter.calls
+	}
+	return limiter.flowCalls[flowName]
+}
+
+// sweep drops ANIs without recent calls,at most once per period.
+func (limiter *CallLimiter) sweep(now time.Time, period time.Duration) {
+
+	if now.Sub(limiter.lastSweep) < period {
+		return
+	}
+	limiter.lastSweep = now
+
+	for ani, times := range limiter.aniCalls {
+		if recent := pruneCallTimes(times, now.Add(-period)); len(recent) > 0 {
+			limiter.aniCalls[ani] = recent
+		} else {
+			delete(limiter.aniCalls, ani)
+		}
+	}
+}
+
+func pruneCallTimes(times []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(times) && !times[i].After(since) {
+		i++
+	}
+	return times[i:]
+}
@@ -0,0 +1,74 @@
+// Limiter test
+
+package ivr
+
+import (
+	"testing"
+)
+
+func TestCallLimiter(t *testing.T) {
+
+	limiter := NewCallLimiter(Limits{MaxCalls: 2, AniMaxCalls: 2, AniPeriod: 60000})
+	bank := &Flow{Name: "bank", MaxCalls: 1}
+	card := &Flow{Name: "card"}
+
+	if err := limiter.Acquire(bank, "1001"); err != nil {
+		t.Fatalf("Acquire first call failure for %s", err.Error())
+	}
+	if err := limiter.Acquire(bank, "1002"); err != overloadErr {
+		t.Fatalf("Flow limit not applied,err=%v", err)
+	}
+	if err := limiter.Acquire(card, "1001"); err != nil {
+		t.Fatalf("Acquire second call failure for %s", err.Error())
+	}
+	if err := limiter.Acquire(card, "1003"); err != overloadErr {
+		t.Fatalf("Global limit not applied,err=%v", err)
+	}
+
+	limiter.Release(bank)
+	limiter.Release(card)
+	if limiter.Calls("") != 0 {
+		t.Fatalf("Calls=%d after release", limiter.Calls(""))
+	}
+
+	if err := limiter.Acquire(card, "1001"); err != rateLimitErr {
+		t.Fatalf("ANI rate limit not applied,err=%v", err)
+	}
+	t.Log("Test pass.")
+}
+
+func TestFindFlow(t *testing.T) {
+
+	config := NewServerConfig()
+	config.Flows.Flow = []Flow{{Name: "bank", DNIS: "9852"}, {Name: "card", DNIS: "98521", Root: "cardRoot"}}
+
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			config.FindFlow("98520")
+			done <- true
+		}()
+	}
+	<-done
+	<-done
+
+	tests := []struct {
+		dnis string
+		name string
+		root string
+	}{
+		{"98520", "bank", Default_Root_Node},
+		{"985211", "card", "cardRoot"},
+		{"400", Default_Flow_Name, Default_Root_Node},
+	}
+	for _, test := range tests {
+		if flow := config.FindFlow(test.dnis); flow.Name != test.name || flow.Root != test.root {
+			t.Fatalf("FindFlow(%s)=%s,%s,expect %s,%s", test.dnis, flow.Name, flow.Root, test.name, test.root)
+		}
+	}
+	if config.Flows.Flow[0].Root != "" {
+		t.Fatal("FindFlow changes the shared config")
+	}
+
+	t.Log("Test pass.")
+}
@@ -0,0 +1,172 @@
+// fs/ivr/ NodeRegistry
+
+package ivr
+
+import (
+	l4g "code.google.com/p/log4go"
+	"encoding/xml"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// NodeDecoder decodes element start of the flow file into a node,it must
+// consume the element up to its end,eg. with decoder.DecodeElement.
+type NodeDecoder func(decoder *xml.Decoder, start xml.StartElement) (IVRNode, error)
+
+var nodeTypes map[string]NodeDecoder = make(map[string]NodeDecoder)
+var nodeTypesMutex sync.RWMutex
+
+func init() {
+	RegisterNode("RootNode", RootNode{})
+	RegisterNode("ExitNode", ExitNode{})
+	RegisterNode("AnnNode", AnnNode{})
+	RegisterNode("MenuNode", MenuNode{})
+	RegisterNode("PromptCollectNode", PromptCollectNode{})
+	RegisterNode("GotoNode", GotoNode{})
+	RegisterNode("TransferNode", TransferNode{})
+	RegisterNode("BridgeNode", BridgeNode{})
+	RegisterNode("RecordNode", RecordNode{})
+	RegisterNode("ConditionNode", ConditionNode{})
+	RegisterNode("SetNode", SetNode{})
+	RegisterNode("HTTPRequestNode", HTTPRequestNode{})
+	RegisterNode("DBQueryNode", DBQueryNode{})
+	RegisterNode("SubFlowNode", SubFlowNode{})
+	RegisterNode("ReturnNode", ReturnNode{})
+	RegisterNode("ScriptNode", ScriptNode{})
+	RegisterNode("ScheduleNode", ScheduleNode{})
+	RegisterNode("SpeechCollectNode", SpeechCollectNode{})
+}
+
+// NodeLoader is implemented by the nodes prepared when their flow file is
+// loaded,eg. to resolve a file against dir,the directory of the flow file,or
+// to check the config.Load returns the node to run,an error fails the load.
+type NodeLoader interface {
+	Load(dir string) (IVRNode, error)
+}
+
+// RegisterNodeType registers the decoder of the nodes written as element in
+// <Nodes>,an element registered again gets the new decoder.Custom nodes are
+// registered from the init of their package,before the flow file is loaded.
+func RegisterNodeType(element string, decoder NodeDecoder) {
+	nodeTypesMutex.Lock()
+	defer nodeTypesMutex.Unlock()
+	nodeTypes[element] = decoder
+}
+
+// RegisterNode registers element with the type of node,the element is decoded
+// by encoding/xml into a new value of the type.
+func RegisterNode(element string, node IVRNode) {
+	nodeType := reflect.TypeOf(node)
+	RegisterNodeType(element, func(decoder *xml.Decoder, start xml.StartElement) (IVRNode, error) {
+		value := reflect.New(nodeType)
+		if err := decoder.DecodeElement(value.Interface(), &start); err != nil {
+			return nil, err
+		}
+		return value.Elem().Interface().(IVRNode), nil
+	})
+}
+
+// NodeTypes returns the registered elements.
+func NodeTypes() []string {
+	nodeTypesMutex.RLock()
+	defer nodeTypesMutex.RUnlock()
+	elements := make([]string, 0, len(nodeTypes))
+	for element := range nodeTypes {
+		elements = append(elements, element)
+	}
+	sort.Strings(elements)
+	return elements
+}
+
+func nodeDecoder(element string) (NodeDecoder, bool) {
+	nodeTypesMutex.RLock()
+	defer nodeTypesMutex.RUnlock()
+	decoder, ok := nodeTypes[element]
+	return decoder, ok
+}
+
+// FlowNode is a node of the flow file,Name is its name attribute.
+type FlowNode struct {
+	Name    string
+	Element string
+	Node    IVRNode
+}
+
+// Nodes are the nodes of a flow file in file order,decoded by the registered
+// node types.Unknown elements are skipped and reported when the flow file is
+// loaded.
+type Nodes struct {
+	Node    []FlowNode
+	unknown []string
+}
+
+func (nodes *Nodes) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			decode, ok := nodeDecoder(element.Name.Local)
+			if !ok {
+				nodes.unknown = append(nodes.unknown, element.Name.Local)
+				if err = decoder.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			name := ""
+			for _, attr := range element.Attr {
+				if attr.Name.Local == "name" {
+					name = attr.Value
+				}
+			}
+
+			node, err := decode(decoder, element)
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				l4g.Warn("%s without name,skip it", element.Name.Local)
+				continue
+			}
+			nodes.Node = append(nodes.Node, FlowNode{Name: name, Element: element.Name.Local, Node: node})
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// Has tells whether the nodes have an element node.
+func (nodes Nodes) Has(element string) bool {
+	for _, node := range nodes.Node {
+		if node.Element == element {
+			return true
+		}
+	}
+	return false
+}
+
+// loadNodes prepares the nodes of flow file name implementing NodeLoader.
+func loadNodes(name string, nodes *Nodes) error {
+	dir := filepath.Dir(name)
+	for i, flowNode := range nodes.Node {
+		loader, ok := flowNode.Node.(NodeLoader)
+		if !ok {
+			continue
+		}
+		node, err := loader.Load(dir)
+		if err != nil {
+			return errors.New(flowNode.Name + " : " + err.Error())
+		}
+		nodes.Node[i].Node = node
+	}
+	return nil
+}
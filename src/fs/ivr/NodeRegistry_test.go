@@ -0,0 +1,122 @@
+// NodeRegistry test
+
+package ivr
+
+import (
+	"encoding/xml"
+	"errors"
+	"testing"
+)
+
+type fraudCheckNode struct {
+	NodeName  string `xml:"name,attr"`
+	Threshold int
+	NextNode  string
+}
+
+func (node fraudCheckNode) Execute(ivrChannel *IVRChannel) (string, error) {
+	return node.NextNode, nil
+}
+
+// Load resolves NextNode for the test,eg. a custom node resolves its files.
+func (node fraudCheckNode) Load(dir string) (IVRNode, error) {
+	if node.Threshold <= 0 {
+		return nil, errors.New("Threshold must be positive")
+	}
+	node.NextNode = dir + "/" + node.NextNode
+	return node, nil
+}
+
+type pingNode struct {
+	name string
+}
+
+func (node *pingNode) Execute(ivrChannel *IVRChannel) (string, error) {
+	return node.name, nil
+}
+
+const testRegistryFlow = `<IVR>
+	<Nodes>
+		<RootNode name="root"><NextNode>fraudCheck</NextNode></RootNode>
+		<FraudCheckNode name="fraudCheck">
+			<Threshold>80</Threshold>
+			<NextNode>ping</NextNode>
+		</FraudCheckNode>
+		<PingNode name="ping" pong="exit"/>
+		<CrmLookupNode name="unknown"><Url>http://crm</Url></CrmLookupNode>
+		<AnnNode><NextNode>exit</NextNode></AnnNode>
+		<ExitNode name="exit"/>
+	</Nodes>
+</IVR>`
+
+func TestNodeRegistry(t *testing.T) {
+
+	RegisterNode("FraudCheckNode", fraudCheckNode{})
+	RegisterNodeType("PingNode", func(decoder *xml.Decoder, start xml.StartElement) (IVRNode, error) {
+		node := new(pingNode)
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "pong" {
+				node.name = attr.Value
+			}
+		}
+		return node, decoder.Skip()
+	})
+	defer func() {
+		nodeTypesMutex.Lock()
+		delete(nodeTypes, "FraudCheckNode")
+		delete(nodeTypes, "PingNode")
+		nodeTypesMutex.Unlock()
+	}()
+
+	var ivrConfig IVRConfig
+	if err := xml.Unmarshal([]byte(testRegistryFlow), &ivrConfig); err != nil {
+		t.Fatalf("Unmarshal flow failure for %s", err.Error())
+	}
+
+	names := make([]string, 0)
+	for _, node := range ivrConfig.Nodes.Node {
+		names = append(names, node.Name)
+	}
+	if len(names) != 4 || names[0] != "root" || names[1] != "fraudCheck" || names[2] != "ping" || names[3] != "exit" {
+		t.Fatalf("Nodes=%v,expect [root fraudCheck ping exit]", names)
+	}
+	if !ivrConfig.Nodes.Has("RootNode") || ivrConfig.Nodes.Has("CrmLookupNode") {
+		t.Fatalf("Has RootNode=%v,CrmLookupNode=%v", ivrConfig.Nodes.Has("RootNode"), ivrConfig.Nodes.Has("CrmLookupNode"))
+	}
+
+	if unknown := ivrConfig.Nodes.unknown; len(unknown) != 1 || unknown[0] != "CrmLookupNode" {
+		t.Fatalf("Unknown=%v,expect [CrmLookupNode]", unknown)
+	}
+
+	fraudCheck, ok := ivrConfig.Nodes.Node[1].Node.(fraudCheckNode)
+	if !ok || fraudCheck.Threshold != 80 || fraudCheck.NextNode != "ping" {
+		t.Fatalf("FraudCheckNode=%#v", ivrConfig.Nodes.Node[1].Node)
+	}
+	if next, _ := ivrConfig.Nodes.Node[2].Node.Execute(nil); next != "exit" {
+		t.Fatalf("PingNode next=%s,expect exit", next)
+	}
+	if root, ok := ivrConfig.Nodes.Node[0].Node.(RootNode); !ok || root.NextNode != "fraudCheck" {
+		t.Fatalf("RootNode=%#v", ivrConfig.Nodes.Node[0].Node)
+	}
+
+	if err := loadNodes("/etc/ivr/ivr.xml", &ivrConfig.Nodes); err != nil {
+		t.Fatalf("Load nodes failure for %s", err.Error())
+	}
+	if fraudCheck := ivrConfig.Nodes.Node[1].Node.(fraudCheckNode); fraudCheck.NextNode != "/etc/ivr/ping" {
+		t.Fatalf("Loaded FraudCheckNode next=%s,expect /etc/ivr/ping", fraudCheck.NextNode)
+	}
+	ivrConfig.Nodes.Node[1].Node = fraudCheckNode{NodeName: "fraudCheck"}
+	if err := loadNodes("/etc/ivr/ivr.xml", &ivrConfig.Nodes); err == nil {
+		t.Fatal("Load invalid FraudCheckNode,expect error")
+	}
+
+	found := false
+	for _, element := range NodeTypes() {
+		found = found || element == "FraudCheckNode"
+	}
+	if !found {
+		t.Fatalf("NodeTypes=%v,expect FraudCheckNode", NodeTypes())
+	}
+
+	t.Log("Test pass.")
+}
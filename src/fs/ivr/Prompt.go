@@ -0,0 +1,196 @@
+// fs/ivr/ Prompt
+
+package ivr
+
+import (
+	"encoding/xml"
+	"fs/ivr/eventsocket"
+	"strconv"
+	"strings"
+)
+
+// PromptPhrase is one part of a prompt,Play returns true when the caller breaks it.
+type PromptPhrase interface {
+	Play(ivrChannel *IVRChannel) (bool, error)
+}
+
+// FilePhrase plays a file of the sound library,or a stream such as
+// file_string://a.wav!b.wav or tone_stream://%(500,0,800).
+type FilePhrase struct {
+	File string `xml:",chardata"`
+}
+
+// SilencePhrase keeps silent for Ms millisecond.
+type SilencePhrase struct {
+	Ms int `xml:"ms,attr"`
+}
+
+// PhraseItem is a phrase of a prompt with its own barge-in and repeat count,
+// set by the bargeIn and repeat attributes of any phrase element.
+type PhraseItem struct {
+	Phrase  PromptPhrase
+	BargeIn *bool // nil follows the prompt.
+	Repeat  int
+}
+
+// Prompt plays its phrases in order,Loop times.eg.
+//
+//	<Prompt name="p_balance">
+//		<BargeIn>true</BargeIn>
+//		<Loop>2</Loop>
+//		<Phrase bargeIn="false">balanceIs.wav</Phrase>
+//		<Say type="CURRENCY" var="balance"/>
+//		<Silence ms="500"/>
+//		<TTS>Press 1 to hear it again.</TTS>
+//	</Prompt>
+type Prompt struct {
+	PName   string
+	BargeIn bool
+	Loop    int
+	Phrases []PhraseItem
+}
+
+func (prompt *Prompt) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "name" {
+			prompt.PName = attr.Value
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch element := token.(type) {
+		case xml.EndElement:
+			return nil
+		case xml.StartElement:
+			var phrase PromptPhrase
+			switch element.Name.Local {
+			case "BargeIn":
+				err = decoder.DecodeElement(&prompt.BargeIn, &element)
+			case "Loop":
+				err = decoder.DecodeElement(&prompt.Loop, &element)
+			case "Phrase":
+				file := FilePhrase{}
+				err = decoder.DecodeElement(&file, &element)
+				file.File = strings.TrimSpace(file.File)
+				phrase = file
+			case "Silence":
+				silence := SilencePhrase{}
+				err = decoder.DecodeElement(&silence, &element)
+				phrase = silence
+			case "TTS":
+				tts := TTSPhrase{}
+				err = decoder.DecodeElement(&tts, &element)
+				phrase = tts
+			case "Say":
+				say := SayPhrase{}
+				err = decoder.DecodeElement(&say, &element)
+				phrase = say
+			default:
+				err = decoder.Skip()
+			}
+			if err != nil {
+				return err
+			}
+			if phrase != nil {
+				prompt.Phrases = append(prompt.Phrases, newPhraseItem(phrase, element.Attr))
+			}
+		}
+	}
+}
+
+func newPhraseItem(phrase PromptPhrase, attrs []xml.Attr) PhraseItem {
+	item := PhraseItem{Phrase: phrase, Repeat: 1}
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "bargeIn":
+			if bargeIn, err := strconv.ParseBool(attr.Value); err == nil {
+				item.BargeIn = &bargeIn
+			}
+		case "repeat":
+			if repeat, err := strconv.Atoi(attr.Value); err == nil && repeat > 0 {
+				item.Repeat = repeat
+			}
+		}
+	}
+	return item
+}
+
+// play plays the phrases Loop times,it returns true when the caller breaks it.
+// A phrase which fails is skipped unless the channel hangs up.
+func (prompt Prompt) play(ivrChannel *IVRChannel) (bool, error) {
+
+	loop := prompt.Loop
+	if loop <= 0 {
+		loop = 1
+	}
+
+	bargeIn := !prompt.BargeIn // Set before the first phrase.
+	for i := 0; i < loop; i++ {
+		for _, item := range prompt.Phrases {
+			itemBargeIn := prompt.BargeIn
+			if item.BargeIn != nil {
+				itemBargeIn = *item.BargeIn
+			}
+			if itemBargeIn != bargeIn {
+				ivrChannel.Esocket.BargeIn(itemBargeIn)
+				bargeIn = itemBargeIn
+			}
+
+			for j := 0; j < item.Repeat; j++ {
+				done, err := item.Phrase.Play(ivrChannel)
+				if err != nil {
+					if ivrChannel.ChannelState == IVRChannel_State_Hangup || err == playCanceledErr {
+						return false, err
+					}
+					ivrChannel.Log.Warn("Play phrase of %s failure for %s", prompt.PName, err.Error())
+					continue
+				}
+				if done {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// soundFile returns the path of name in the sound library of lang,streams and
+// absolute paths are returned as is.The files of a file_string are resolved one by one.
+func soundFile(name, lang string) string {
+	if strings.HasPrefix(name, "file_string://") {
+		files := strings.Split(strings.TrimPrefix(name, "file_string://"), "!")
+		for i, file := range files {
+			files[i] = soundFile(file, lang)
+		}
+		return "file_string://" + strings.Join(files, "!")
+	}
+	if strings.Contains(name, "://") || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "$") {
+		return name
+	}
+	if lang != "" {
+		return eventsocket.Ivr_Sound_Path + lang + "/" + name
+	}
+	return eventsocket.Ivr_Sound_Path + name
+}
+
+func (phrase FilePhrase) Play(ivrChannel *IVRChannel) (bool, error) {
+	return ivrChannel.playback(soundFile(ivrChannel.Vars.Expand(phrase.File), ivrChannel.language()))
+}
+
+func (phrase SilencePhrase) Play(ivrChannel *IVRChannel) (bool, error) {
+	return ivrChannel.playback("silence_stream://" + strconv.Itoa(phrase.Ms))
+}
+
+func (phrase TTSPhrase) Play(ivrChannel *IVRChannel) (bool, error) {
+	return ivrChannel.speak(phrase)
+}
+
+func (phrase SayPhrase) Play(ivrChannel *IVRChannel) (bool, error) {
+	return ivrChannel.say(phrase)
+}
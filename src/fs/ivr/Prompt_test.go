@@ -0,0 +1,60 @@
+// Prompt test
+
+package ivr
+
+import (
+	"encoding/xml"
+	"fs/ivr/eventsocket"
+	"testing"
+)
+
+func TestUnmarshalPrompt(t *testing.T) {
+
+	content := `<Prompts>
+		<Prompt name="p_balance">
+			<BargeIn>true</BargeIn>
+			<Loop>2</Loop>
+			<Phrase bargeIn="false">balanceIs.wav</Phrase>
+			<Say type="CURRENCY" var="balance"/>
+			<Silence ms="500" repeat="2"/>
+			<TTS voice="slt">Press 1 to hear it again.</TTS>
+		</Prompt>
+	</Prompts>`
+
+	var prompts Prompts
+	if err := xml.Unmarshal([]byte(content), &prompts); err != nil {
+		t.Fatalf("Unmarshal prompt failure for %s", err.Error())
+	}
+
+	prompt := prompts.Prompt[0]
+	if prompt.PName != "p_balance" || !prompt.BargeIn || prompt.Loop != 2 || len(prompt.Phrases) != 4 {
+		t.Fatalf("Prompt=%+v", prompt)
+	}
+	if file, ok := prompt.Phrases[0].Phrase.(FilePhrase); !ok || file.File != "balanceIs.wav" || *prompt.Phrases[0].BargeIn {
+		t.Fatalf("Phrase 0=%+v", prompt.Phrases[0])
+	}
+	if say, ok := prompt.Phrases[1].Phrase.(SayPhrase); !ok || say.Var != "balance" || prompt.Phrases[1].BargeIn != nil {
+		t.Fatalf("Phrase 1=%+v", prompt.Phrases[1])
+	}
+	if silence, ok := prompt.Phrases[2].Phrase.(SilencePhrase); !ok || silence.Ms != 500 || prompt.Phrases[2].Repeat != 2 {
+		t.Fatalf("Phrase 2=%+v", prompt.Phrases[2])
+	}
+	if tts, ok := prompt.Phrases[3].Phrase.(TTSPhrase); !ok || tts.Voice != "slt" {
+		t.Fatalf("Phrase 3=%+v", prompt.Phrases[3])
+	}
+	t.Log("Test pass.")
+}
+
+func TestSoundFile(t *testing.T) {
+
+	if file := soundFile("welcome.wav", ""); file != eventsocket.Ivr_Sound_Path+"welcome.wav" {
+		t.Fatalf("Sound file=%s", file)
+	}
+	if file := soundFile("file_string://a.wav!/tmp/b.wav", "en"); file != "file_string://"+eventsocket.Ivr_Sound_Path+"en/a.wav!/tmp/b.wav" {
+		t.Fatalf("Sound file=%s", file)
+	}
+	if file := soundFile("tone_stream://%(500,0,800)", "en"); file != "tone_stream://%(500,0,800)" {
+		t.Fatalf("Sound file=%s", file)
+	}
+	t.Log("Test pass.")
+}
@@ -0,0 +1,240 @@
+// fs/ivr/ RecordNode
+
+package ivr
+
+import (
+	"errors"
+	"fs/ivr/eventsocket"
+	"path"
+	"strconv"
+	"time"
+)
+
+const Default_Record_Path string = "/usr/local/freeswitch/recordings"
+const Default_Record_Format string = "wav"
+const Default_Record_Terminators string = "#"
+const Default_Silence_Threshold int = 200
+const Default_Review_Timeout int = 5000
+const Default_Review_Times int = 3
+
+const Record_Beep string = "tone_stream://%(500,0,800)"
+
+const Record_Reason_Terminator string = "terminator"
+const Record_Reason_Silence string = "silence"
+const Record_Reason_MaxDuration string = "maxduration"
+const Record_Reason_Hangup string = "hangup"
+
+// RecordReview lets the caller listen to the message,record it again or accept it.
+// The message is accepted after MaxTimes reviews without a valid choice.
+type RecordReview struct {
+	Prompts  PromptEntity
+	Listen   string // DTMF.
+	ReRecord string
+	Accept   string
+	Timeout  int // Millisecond.
+	MaxTimes int
+}
+
+// RecordNode records the caller to <Path>/<callId>-<yyyyMMddHHmmss>.<Format>
+// until a terminator is pressed,SilenceHits seconds of silence or MaxDuration.
+// The file is kept in call variables record_path,record_duration (millisecond)
+// and record_reason and in the call record.
+type RecordNode struct {
+	NodeName         string `xml:"name,attr"`
+	Prompts          PromptEntity
+	Beep             bool
+	Path             string
+	Format           string // wav or mp3,default wav.
+	MaxDuration      int    // Second,0 for no limit.
+	SilenceThreshold int    // Energy level,default 200.
+	SilenceHits      int    // Second,0 disables silence detection.
+	Terminators      string // Default #.
+	Review           *RecordReview
+	NextNode         string
+	OnFailure        string
+}
+
+func (node RecordNode) filePath(ivrChannel *IVRChannel) string {
+	dir := node.Path
+	if dir == "" {
+		dir = Default_Record_Path
+	}
+	format := node.Format
+	if format == "" {
+		format = Default_Record_Format
+	}
+	callId := ivrChannel.Vars.Get("callId")
+	if callId == "" {
+		callId = ivrChannel.ChannelId
+	}
+	return path.Join(dir, callId+"-"+time.Now().Format("20060102150405")+"."+format)
+}
+
+func (node RecordNode) recordArg(file string) string {
+	arg := file + " " + strconv.Itoa(node.MaxDuration)
+	if node.SilenceHits > 0 {
+		threshold := node.SilenceThreshold
+		if threshold <= 0 {
+			threshold = Default_Silence_Threshold
+		}
+		arg = arg + " " + strconv.Itoa(threshold) + " " + strconv.Itoa(node.SilenceHits)
+	}
+	return arg
+}
+
+// recordReason tells why the record app stopped from its CHANNEL_EXECUTE_COMPLETE.
+func (node RecordNode) recordReason(event *eventsocket.Event, duration int) string {
+	if event.Header["variable_playback_terminator_used"] != "" {
+		return Record_Reason_Terminator
+	}
+	switch event.Header["variable_record_completion_cause"] {
+	case "success-maxtime":
+		return Record_Reason_MaxDuration
+	case "success-silence", "no-input-timeout":
+		return Record_Reason_Silence
+	}
+	if node.MaxDuration > 0 && duration >= node.MaxDuration*1000-1000 {
+		return Record_Reason_MaxDuration
+	}
+	return Record_Reason_Silence
+}
+
+func recordDuration(event *eventsocket.Event, elapsed time.Duration) int {
+	if ms, err := strconv.Atoi(event.Header["variable_record_ms"]); err == nil {
+		return ms
+	}
+	if seconds, err := strconv.Atoi(event.Header["variable_record_seconds"]); err == nil {
+		return seconds * 1000
+	}
+	return int(elapsed / time.Millisecond)
+}
+
+// record records file once,a message cut by hangup is still kept.
+func (node RecordNode) record(ivrChannel *IVRChannel, file string) error {
+
+	if node.Beep {
+		if _, err := ivrChannel.playback(Record_Beep); err != nil {
+			return err
+		}
+	}
+
+	terminators := node.Terminators
+	if terminators == "" {
+		terminators = Default_Record_Terminators
+	}
+	ivrChannel.Esocket.SetVar("playback_terminators", terminators)
+	ivrChannel.Esocket.SetVar("playback_terminator_used", "")
+
+	ivrChannel.Log.Info("Record caller to %s", file)
+	start := time.Now()
+	event, err := ivrChannel.executeWait("record", node.recordArg(file), 0)
+	recording := Recording{Node: node.NodeName, Path: file, RecordTime: start}
+	if err != nil {
+		if ivrChannel.ChannelState != IVRChannel_State_Hangup {
+			return err
+		}
+		recording.Duration = int(time.Since(start) / time.Millisecond)
+		recording.Reason = Record_Reason_Hangup
+	} else {
+		ivrChannel.channelVars(event, "playback_terminator_used", "record_completion_cause", "record_ms", "record_seconds")
+		recording.Duration = recordDuration(event, time.Since(start))
+		recording.Reason = node.recordReason(event, recording.Duration)
+	}
+
+	ivrChannel.Vars.Set("record_path", recording.Path)
+	ivrChannel.Vars.Set("record_duration", strconv.Itoa(recording.Duration))
+	ivrChannel.Vars.Set("record_reason", recording.Reason)
+	ivrChannel.Record.AddRecording(recording)
+	ivrChannel.Log.Info("Record done duration=%dms,reason=%s", recording.Duration, recording.Reason)
+
+	return err
+}
+
+// review runs the review menu until the message is accepted.
+func (node RecordNode) review(ivrChannel *IVRChannel, file string) error {
+
+	review := node.Review
+	timeoutMs := review.Timeout
+	if timeoutMs <= 0 {
+		timeoutMs = Default_Review_Timeout
+	}
+	maxTimes := review.MaxTimes
+	if maxTimes <= 0 {
+		maxTimes = Default_Review_Times
+	}
+
+	ivrChannel.Esocket.StartDTMF()
+	defer ivrChannel.Esocket.StopDTMF()
+
+	for times := 0; times < maxTimes; {
+
+		// Clear dtmf channel value,eg. the record terminator.
+		for len(ivrChannel.Dtmf) > 0 {
+			<-ivrChannel.Dtmf
+		}
+
+		executePrompt(review.Prompts.Prompt, ivrChannel)
+
+		timeout := eventsocket.CheckTimeout(timeoutMs)
+		select {
+		case <-timeout:
+			ivrChannel.Log.Warn("Timeout,no dtmf.")
+			times = times + 1
+		case dtmf := <-ivrChannel.Dtmf:
+			switch dtmf {
+			case review.Accept:
+				return nil
+			case review.Listen:
+				ivrChannel.Esocket.BargeIn(true)
+				if _, err := ivrChannel.playback(file); err != nil {
+					return err
+				}
+			case review.ReRecord:
+				if err := node.record(ivrChannel, file); err != nil {
+					return err
+				}
+			default:
+				ivrChannel.Log.Warn("No match for dtmf=%s", dtmf)
+				times = times + 1
+			}
+		case <-ivrChannel.ChannelHangup:
+			ivrChannel.Log.Trace("Channel hangup.")
+			return errors.New("Channel hangup.")
+		}
+	}
+
+	ivrChannel.Log.Info("Review times reach %d,accept the message", maxTimes)
+	return nil
+}
+
+func (node RecordNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	executePrompt(node.Prompts.Prompt, ivrChannel)
+
+	file := node.filePath(ivrChannel)
+	if err := node.record(ivrChannel, file); err != nil {
+		if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+			return "", err
+		}
+		ivrChannel.Log.Warn("Record failure for %s", err.Error())
+		return node.OnFailure, nil
+	}
+
+	if node.Review != nil {
+		if err := node.review(ivrChannel, file); err != nil {
+			if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+				return "", err
+			}
+			ivrChannel.Log.Warn("Review record failure for %s", err.Error())
+			return node.OnFailure, nil
+		}
+	}
+
+	return node.NextNode, nil
+}
@@ -0,0 +1,90 @@
+// RecordNode test
+
+package ivr
+
+import (
+	"fs/ivr/calllog"
+	"fs/ivr/eventsocket"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordNode(t *testing.T) {
+
+	args := []struct {
+		node RecordNode
+		arg  string
+	}{
+		{RecordNode{MaxDuration: 120}, "/tmp/call-1.wav 120"},
+		{RecordNode{MaxDuration: 120, SilenceHits: 5}, "/tmp/call-1.wav 120 200 5"},
+		{RecordNode{SilenceThreshold: 300, SilenceHits: 3}, "/tmp/call-1.wav 0 300 3"},
+		{RecordNode{SilenceThreshold: 300}, "/tmp/call-1.wav 0"},
+	}
+	for _, test := range args {
+		if arg := test.node.recordArg("/tmp/call-1.wav"); arg != test.arg {
+			t.Fatalf("recordArg=%s,expect %s", arg, test.arg)
+		}
+	}
+
+	node := RecordNode{MaxDuration: 60}
+	reasons := []struct {
+		header   eventsocket.EventHeader
+		duration int
+		reason   string
+	}{
+		{eventsocket.EventHeader{"variable_playback_terminator_used": "#"}, 3000, Record_Reason_Terminator},
+		{eventsocket.EventHeader{"variable_record_completion_cause": "success-maxtime"}, 3000, Record_Reason_MaxDuration},
+		{eventsocket.EventHeader{"variable_record_completion_cause": "success-silence"}, 3000, Record_Reason_Silence},
+		{eventsocket.EventHeader{"variable_record_completion_cause": "no-input-timeout"}, 0, Record_Reason_Silence},
+		{eventsocket.EventHeader{}, 59500, Record_Reason_MaxDuration},
+		{eventsocket.EventHeader{}, 30000, Record_Reason_Silence},
+	}
+	for _, test := range reasons {
+		if reason := node.recordReason(&eventsocket.Event{Header: test.header}, test.duration); reason != test.reason {
+			t.Fatalf("recordReason(%v,%d)=%s,expect %s", test.header, test.duration, reason, test.reason)
+		}
+	}
+
+	durations := []struct {
+		header   eventsocket.EventHeader
+		duration int
+	}{
+		{eventsocket.EventHeader{"variable_record_ms": "3250", "variable_record_seconds": "3"}, 3250},
+		{eventsocket.EventHeader{"variable_record_seconds": "3"}, 3000},
+		{eventsocket.EventHeader{}, 1500},
+	}
+	for _, test := range durations {
+		if duration := recordDuration(&eventsocket.Event{Header: test.header}, 1500*time.Millisecond); duration != test.duration {
+			t.Fatalf("recordDuration(%v)=%d,expect %d", test.header, duration, test.duration)
+		}
+	}
+
+	t.Log("Test pass.")
+}
+
+func TestRecordNodeReview(t *testing.T) {
+
+	ivrChannel := &IVRChannel{Vars: NewCallVars(), Log: calllog.New(), Dtmf: make(chan string, Max_DTMF_Length)}
+	closeSwitch := fakeSwitch(ivrChannel, func(cmd string) {
+		// Digits are only sent to the socket once start_dtmf runs.
+		if strings.Contains(cmd, "start_dtmf") {
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				ivrChannel.Dtmf <- "3"
+			}()
+		}
+	})
+	defer closeSwitch()
+
+	node := RecordNode{NodeName: "voiceMail", Review: &RecordReview{Listen: "1", ReRecord: "2", Accept: "3", Timeout: 2000, MaxTimes: 1}}
+	start := time.Now()
+	if err := node.review(ivrChannel, "/tmp/call-1.wav"); err != nil {
+		t.Fatalf("Review failure for %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Review accepted after %s,expect the dtmf to be started", elapsed)
+	}
+
+	t.Log("Test pass.")
+}
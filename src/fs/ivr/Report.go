@@ -0,0 +1,381 @@
+// fs/ivr/ Report
+
+package ivr
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const Report_Format_Text string = "text"
+const Report_Format_JSON string = "json"
+const Report_Format_CSV string = "csv"
+
+// RecordReader reads back the call records of a store.
+type RecordReader interface {
+	ReadRecords(from, to time.Time, flow string) ([]*CallRecord, error)
+	Close()
+}
+
+type NodeCount struct {
+	Node  string
+	Count int
+}
+
+type InputCount struct {
+	Node  string
+	Input string
+	Count int
+}
+
+type PathCount struct {
+	Path  string
+	Count int
+}
+
+type Report struct {
+	From            time.Time
+	To              time.Time
+	Flow            string
+	Calls           int
+	SelfServed      int
+	Transferred     int
+	Abandoned       int
+	ContainmentRate float64 // Self served calls / calls.
+	AvgSeconds      float64 // Average time in flow.
+	DropOffs        []NodeCount
+	NoMatches       []InputCount // Top inputs of each node.
+	Paths           []PathCount
+}
+
+// NewRecordReader opens the store selected by config for reports.
+func NewRecordReader(config Persistence) (RecordReader, error) {
+
+	config = config.withDefaults()
+
+	if config.Type == Persist_Type_JSONL {
+		return NewFilePersistor(config.File, 0, false), nil
+	}
+
+	persistor := newConfigDBPersistor(config)
+	if persistor == nil {
+		return nil, errors.New("No records for persistence type " + config.Type)
+	}
+	if err := persistor.Open(); err != nil {
+		persistor.Close()
+		return nil, err
+	}
+	return persistor, nil
+}
+
+// BuildReport computes the reports of records,topN limits the paths and the
+// NoMatch inputs of each node.
+func BuildReport(records []*CallRecord, from, to time.Time, flow string, topN int) *Report {
+
+	report := &Report{From: from, To: to, Flow: flow}
+	report.DropOffs = make([]NodeCount, 0)
+	report.NoMatches = make([]InputCount, 0)
+	report.Paths = make([]PathCount, 0)
+
+	dropOffs := make(map[string]int)
+	noMatches := make(map[string]map[string]int)
+	paths := make(map[string]int)
+	totalSeconds := 0.0
+
+	for _, record := range records {
+		report.Calls++
+		switch record.Outcome {
+		case Outcome_SelfServed:
+			report.SelfServed++
+		case Outcome_Transferred:
+			report.Transferred++
+		case Outcome_Abandoned:
+			report.Abandoned++
+		}
+
+		if !record.EndTime.IsZero() && record.EndTime.After(record.StartTime) {
+			totalSeconds = totalSeconds + record.EndTime.Sub(record.StartTime).Seconds()
+		}
+
+		nodes := make([]string, 0, len(record.Path))
+		for _, visit := range record.Path {
+			nodes = append(nodes, visit.Node)
+		}
+		if len(nodes) > 0 {
+			paths[strings.Join(nodes, ">")]++
+			if record.Outcome == Outcome_Abandoned {
+				dropOffs[nodes[len(nodes)-1]]++
+			}
+		}
+
+		for _, input := range record.Inputs {
+			if input.Result != Input_NoMatch || input.Sensitive {
+				continue
+			}
+			if noMatches[input.Node] == nil {
+				noMatches[input.Node] = make(map[string]int)
+			}
+			noMatches[input.Node][input.Value]++
+		}
+	}
+
+	if report.Calls > 0 {
+		report.ContainmentRate = float64(report.SelfServed) / float64(report.Calls)
+		report.AvgSeconds = totalSeconds / float64(report.Calls)
+	}
+
+	for node, count := range dropOffs {
+		report.DropOffs = append(report.DropOffs, NodeCount{node, count})
+	}
+	sort.Slice(report.DropOffs, func(i, j int) bool {
+		a, b := report.DropOffs[i], report.DropOffs[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Node < b.Node)
+	})
+
+	nodes := make([]string, 0, len(noMatches))
+	for node := range noMatches {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		inputs := make([]InputCount, 0)
+		for input, count := range noMatches[node] {
+			inputs = append(inputs, InputCount{node, input, count})
+		}
+		sort.Slice(inputs, func(i, j int) bool {
+			return inputs[i].Count > inputs[j].Count || (inputs[i].Count == inputs[j].Count && inputs[i].Input < inputs[j].Input)
+		})
+		if topN > 0 && len(inputs) > topN {
+			inputs = inputs[:topN]
+		}
+		report.NoMatches = append(report.NoMatches, inputs...)
+	}
+
+	for path, count := range paths {
+		report.Paths = append(report.Paths, PathCount{path, count})
+	}
+	sort.Slice(report.Paths, func(i, j int) bool {
+		a, b := report.Paths[i], report.Paths[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Path < b.Path)
+	})
+	if topN > 0 && len(report.Paths) > topN {
+		report.Paths = report.Paths[:topN]
+	}
+
+	return report
+}
+
+func (report *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case Report_Format_JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case Report_Format_CSV:
+		return report.writeCSV(w)
+	case Report_Format_Text, "":
+		return report.writeText(w)
+	}
+	return errors.New("Unknown report format " + format)
+}
+
+func (report *Report) writeText(w io.Writer) error {
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	flow := report.Flow
+	if flow == "" {
+		flow = "all"
+	}
+	fmt.Fprintf(tw, "IVR report %s ~ %s flow=%s\n\n", report.From.Format("2006-01-02 15:04"), report.To.Format("2006-01-02 15:04"), flow)
+	fmt.Fprintf(tw, "Calls\t%d\n", report.Calls)
+	fmt.Fprintf(tw, "Self served\t%d\n", report.SelfServed)
+	fmt.Fprintf(tw, "Transferred\t%d\n", report.Transferred)
+	fmt.Fprintf(tw, "Abandoned\t%d\n", report.Abandoned)
+	fmt.Fprintf(tw, "Containment rate\t%.1f%%\n", report.ContainmentRate*100)
+	fmt.Fprintf(tw, "Average time in flow\t%.1fs\n", report.AvgSeconds)
+
+	fmt.Fprintf(tw, "\nDrop-off by node\n")
+	for _, dropOff := range report.DropOffs {
+		fmt.Fprintf(tw, "  %s\t%d\n", dropOff.Node, dropOff.Count)
+	}
+
+	fmt.Fprintf(tw, "\nNoMatch inputs by node\n")
+	for _, noMatch := range report.NoMatches {
+		fmt.Fprintf(tw, "  %s\t%s\t%d\n", noMatch.Node, noMatch.Input, noMatch.Count)
+	}
+
+	fmt.Fprintf(tw, "\nTop paths\n")
+	for _, path := range report.Paths {
+		fmt.Fprintf(tw, "  %d\t%s\n", path.Count, path.Path)
+	}
+	return tw.Flush()
+}
+
+// writeCSV writes one row per value : report,name,value.
+func (report *Report) writeCSV(w io.Writer) error {
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"report", "name", "value"})
+	cw.Write([]string{"summary", "calls", strconv.Itoa(report.Calls)})
+	cw.Write([]string{"summary", "selfServed", strconv.Itoa(report.SelfServed)})
+	cw.Write([]string{"summary", "transferred", strconv.Itoa(report.Transferred)})
+	cw.Write([]string{"summary", "abandoned", strconv.Itoa(report.Abandoned)})
+	cw.Write([]string{"summary", "containmentRate", strconv.FormatFloat(report.ContainmentRate, 'f', 4, 64)})
+	cw.Write([]string{"summary", "avgSeconds", strconv.FormatFloat(report.AvgSeconds, 'f', 1, 64)})
+	for _, dropOff := range report.DropOffs {
+		cw.Write([]string{"dropOff", dropOff.Node, strconv.Itoa(dropOff.Count)})
+	}
+	for _, noMatch := range report.NoMatches {
+		cw.Write([]string{"noMatch", noMatch.Node + ":" + noMatch.Input, strconv.Itoa(noMatch.Count)})
+	}
+	for _, path := range report.Paths {
+		cw.Write([]string{"path", path.Path, strconv.Itoa(path.Count)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadRecords reads the records started in [from,to),all flows when flow is empty.
+func (persistor *DBPersistor) ReadRecords(from, to time.Time, flow string) ([]*CallRecord, error) {
+
+	where := " where c.StartTime >= ? and c.StartTime < ? and (c.Flow = ? or ? = '')"
+	args := []interface{}{from, to, flow, flow}
+
+	rows, err := persistor.DB.Query(persistor.rebind("select c.CallId,c.ChannelId,c.Flow,c.ANI,c.DNIS,c.StartTime,c.AnswerTime,c.EndTime,c.HangupCause,c.Outcome,c.NoInputTimes,c.NoMatchTimes from IvrCdr c"+where+" order by c.StartTime"), args...)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*CallRecord, 0)
+	recordMap := make(map[string]*CallRecord)
+	for rows.Next() {
+		record := NewCallRecord(time.Time{})
+		var answerTime sql.NullTime
+		err = rows.Scan(&record.CallId, &record.ChannelId, &record.Flow, &record.ANI, &record.DNIS, &record.StartTime, &answerTime,
+			&record.EndTime, &record.HangupCause, &record.Outcome, &record.NoInputTimes, &record.NoMatchTimes)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		record.AnswerTime = answerTime.Time
+		records = append(records, record)
+		recordMap[record.CallId] = record
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = persistor.DB.Query(persistor.rebind("select v.CallId,v.Node,v.EnterTime,v.LeaveTime,v.Result from IvrNodeVisit v join IvrCdr c on c.CallId = v.CallId"+where+" order by v.CallId,v.Seq"), args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var callId string
+		var visit NodeVisit
+		var leaveTime sql.NullTime
+		if err = rows.Scan(&callId, &visit.Node, &visit.EnterTime, &leaveTime, &visit.Result); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		visit.LeaveTime = leaveTime.Time
+		if record, ok := recordMap[callId]; ok {
+			record.Path = append(record.Path, visit)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = persistor.DB.Query(persistor.rebind("select i.CallId,i.Node,i.Value,i.Sensitive,i.Result,i.InputTime from IvrInput i join IvrCdr c on c.CallId = i.CallId"+where+" order by i.CallId,i.Seq"), args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var callId string
+		var input CollectedInput
+		if err = rows.Scan(&callId, &input.Node, &input.Value, &input.Sensitive, &input.Result, &input.InputTime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if record, ok := recordMap[callId]; ok {
+			record.Inputs = append(record.Inputs, input)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = persistor.DB.Query(persistor.rebind("select r.CallId,r.Node,r.Path,r.Duration,r.Reason,r.RecordTime from IvrRecording r join IvrCdr c on c.CallId = r.CallId"+where+" order by r.CallId,r.Seq"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var callId string
+		var recording Recording
+		if err = rows.Scan(&callId, &recording.Node, &recording.Path, &recording.Duration, &recording.Reason, &recording.RecordTime); err != nil {
+			return nil, err
+		}
+		if record, ok := recordMap[callId]; ok {
+			record.Recordings = append(record.Recordings, recording)
+		}
+	}
+	return records, rows.Err()
+}
+
+// ReadRecords reads the records started in [from,to) from File and its rotated files.
+func (persistor *FilePersistor) ReadRecords(from, to time.Time, flow string) ([]*CallRecord, error) {
+
+	files, err := filepath.Glob(persistor.File + ".*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	files = append(files, persistor.File)
+
+	records := make([]*CallRecord, 0)
+	for _, file := range files {
+		fileRecords, err := readRecordFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range fileRecords {
+			if record.StartTime.Before(from) || !record.StartTime.Before(to) {
+				continue
+			}
+			if flow != "" && record.Flow != flow {
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// WriteReport builds the report of the store of the server config.
+func WriteReport(w io.Writer, from, to time.Time, flow string, topN int, format string) error {
+
+	reader, err := NewRecordReader(serverConfig.Persistence)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	records, err := reader.ReadRecords(from, to, flow)
+	if err != nil {
+		return err
+	}
+	return BuildReport(records, from, to, flow, topN).Write(w, format)
+}
@@ -0,0 +1,80 @@
+// Report test
+
+package ivr
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRecord(callId, outcome string, start time.Time, path []string, noMatch string) *CallRecord {
+	record := NewCallRecord(start)
+	record.CallId = callId
+	record.Flow = "bank"
+	record.Outcome = outcome
+	record.EndTime = start.Add(30 * time.Second)
+	for _, node := range path {
+		record.Path = append(record.Path, NodeVisit{Node: node, EnterTime: start, LeaveTime: start})
+	}
+	if noMatch != "" {
+		record.Inputs = append(record.Inputs, CollectedInput{Node: "languageMenu", Value: noMatch, Result: Input_NoMatch, InputTime: start})
+	}
+	return record
+}
+
+func TestReportSQLite(t *testing.T) {
+
+	config := Persistence{Type: Persist_Type_SQLite, DBName: filepath.Join(t.TempDir(), "ivr.db")}
+	if _, err := MigrateStore(config); err != nil {
+		t.Fatalf("Migrate failure for %s", err.Error())
+	}
+
+	day := time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local)
+	records := []*CallRecord{
+		newTestRecord("call-1", Outcome_SelfServed, day, []string{"root", "welcome", "languageMenu", "exit"}, "9"),
+		newTestRecord("call-2", Outcome_Abandoned, day.Add(time.Hour), []string{"root", "welcome", "languageMenu"}, "9"),
+		newTestRecord("call-3", Outcome_SelfServed, day.Add(2*time.Hour), []string{"root", "welcome", "languageMenu", "exit"}, "7"),
+		newTestRecord("call-4", Outcome_SelfServed, day.AddDate(0, 0, 1), []string{"root"}, ""),
+	}
+
+	persistor := newConfigDBPersistor(config)
+	if err := persistor.Open(); err != nil {
+		t.Fatalf("Open failure for %s", err.Error())
+	}
+	defer persistor.Close()
+	if err := persistor.WriteRecords(records); err != nil {
+		t.Fatalf("WriteRecords failure for %s", err.Error())
+	}
+
+	from := time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local)
+	read, err := persistor.ReadRecords(from, from.AddDate(0, 0, 1), "bank")
+	if err != nil {
+		t.Fatalf("ReadRecords failure for %s", err.Error())
+	}
+
+	report := BuildReport(read, from, from.AddDate(0, 0, 1), "bank", 1)
+	if report.Calls != 3 || report.SelfServed != 2 || report.Abandoned != 1 {
+		t.Fatalf("Report calls=%d,selfServed=%d,abandoned=%d", report.Calls, report.SelfServed, report.Abandoned)
+	}
+	if len(report.DropOffs) != 1 || report.DropOffs[0].Node != "languageMenu" {
+		t.Fatalf("Report dropOffs=%v", report.DropOffs)
+	}
+	if len(report.NoMatches) != 1 || report.NoMatches[0].Input != "9" || report.NoMatches[0].Count != 2 {
+		t.Fatalf("Report noMatches=%v", report.NoMatches)
+	}
+	if len(report.Paths) != 1 || report.Paths[0].Path != "root>welcome>languageMenu>exit" || report.Paths[0].Count != 2 {
+		t.Fatalf("Report paths=%v", report.Paths)
+	}
+	if report.AvgSeconds != 30 {
+		t.Fatalf("Report avgSeconds=%f", report.AvgSeconds)
+	}
+
+	var out bytes.Buffer
+	if err := report.Write(&out, Report_Format_CSV); err != nil || !strings.Contains(out.String(), "summary,calls,3") {
+		t.Fatalf("Write csv err=%v,out=%s", err, out.String())
+	}
+	t.Log("Test pass.")
+}
@@ -0,0 +1,139 @@
+// fs/ivr/ Say
+
+package ivr
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const Default_Say_Lang string = "en"
+const Default_Digits_Path string = "digits"
+
+const Say_Type_Number string = "NUMBER"
+const Say_Type_Items string = "ITEMS"
+const Say_Type_Digits string = "DIGITS"
+const Say_Type_Currency string = "CURRENCY"
+const Say_Type_DateTime string = "DATE_TIME"
+
+const Say_Method_Pronounced string = "pronounced"
+const Say_Method_Iterated string = "iterated"
+const Say_Method_Counted string = "counted"
+
+// SayPhrase reads a value with the say app of FreeSWITCH,eg.
+// <Say lang="en" type="CURRENCY" method="pronounced" var="balance"/>
+// The value is the call variable Var (DtmfValue for the collected digits)
+// or the text of the element,which may hold ${name}.
+type SayPhrase struct {
+	Lang   string `xml:"lang,attr"`
+	Type   string `xml:"type,attr"`   // NUMBER,ITEMS,DIGITS,CURRENCY or DATE_TIME,other say types are passed as is.
+	Method string `xml:"method,attr"` // pronounced,iterated or counted.
+	Gender string `xml:"gender,attr"`
+	Var    string `xml:"var,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// Digit sound files of the fallback,the others are named by the digit itself.
+var digitFiles = map[rune]string{
+	'*': "star",
+	'#': "pound",
+	'.': "point",
+	'-': "minus",
+}
+
+func (say SayPhrase) value(ivrChannel *IVRChannel) string {
+	if say.Var != "" {
+		return ivrChannel.Vars.Get(say.Var)
+	}
+	return strings.TrimSpace(ivrChannel.Vars.Expand(say.Value))
+}
+
+// sayArg builds the arg of say : <lang> <type> <method> [gender] <value>,
+// lang defaults to the language of the call.
+// DIGITS is NUMBER iterated and DATE_TIME is CURRENT_DATE_TIME,which reads
+// an epoch so a 2006-01-02 15:04:05 value is converted first.
+func (say SayPhrase) sayArg(value, callLang string) string {
+	lang := say.Lang
+	if lang == "" {
+		lang = callLang
+	}
+	if lang == "" {
+		lang = Default_Say_Lang
+	}
+	sayType := strings.ToUpper(say.Type)
+	method := strings.ToLower(say.Method)
+	if method == "" {
+		method = Say_Method_Pronounced
+	}
+
+	switch sayType {
+	case "":
+		sayType = Say_Type_Number
+	case Say_Type_Digits:
+		sayType = Say_Type_Number
+		method = Say_Method_Iterated
+	case Say_Type_DateTime:
+		sayType = "CURRENT_DATE_TIME"
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+			value = strconv.FormatInt(t.Unix(), 10)
+		}
+	}
+
+	arg := lang + " " + sayType + " " + method
+	if say.Gender != "" {
+		arg = arg + " " + say.Gender
+	}
+	return arg + " " + value
+}
+
+// digitsFile concatenates the digit files of value into a file_string.
+func digitsFile(value, lang string) string {
+	digitsPath := serverConfig.Say.DigitsPath
+	if digitsPath == "" {
+		digitsPath = Default_Digits_Path
+	}
+
+	files := make([]string, 0, len(value))
+	for _, digit := range value {
+		name, ok := digitFiles[digit]
+		if !ok {
+			if digit < '0' || digit > '9' {
+				continue
+			}
+			name = string(digit)
+		}
+		files = append(files, strings.TrimSuffix(digitsPath, "/")+"/"+name+".wav")
+	}
+	if len(files) == 0 {
+		return ""
+	}
+	return soundFile("file_string://"+strings.Join(files, "!"), lang)
+}
+
+// say reads say to the caller,it returns true when the caller breaks it.
+func (ivrChannel *IVRChannel) say(say SayPhrase) (bool, error) {
+
+	value := say.value(ivrChannel)
+	if value == "" {
+		ivrChannel.Log.Warn("Nothing to say for var=%s", say.Var)
+		return false, nil
+	}
+
+	if serverConfig.Say.Fallback {
+		file := digitsFile(value, ivrChannel.language())
+		if file == "" {
+			return false, nil
+		}
+		return ivrChannel.playback(file)
+	}
+
+	ivrChannel.Esocket.SetVar("playback_terminator_used", "")
+	event, err := ivrChannel.executeWait("say", say.sayArg(value, ivrChannel.language()), 0)
+	if err != nil {
+		ivrChannel.Log.Warn("Say failure for %s", err.Error())
+		return false, err
+	}
+	ivrChannel.channelVars(event, "playback_terminator_used")
+	return event.Header["variable_playback_terminator_used"] != "", nil
+}
@@ -0,0 +1,43 @@
+// Say test
+
+package ivr
+
+import (
+	"fs/ivr/eventsocket"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSayArg(t *testing.T) {
+
+	say := SayPhrase{Type: "DIGITS", Method: "pronounced"}
+	if arg := say.sayArg("1024", ""); arg != "en NUMBER iterated 1024" {
+		t.Fatalf("Say arg=%s", arg)
+	}
+
+	say = SayPhrase{Lang: "zh", Type: "currency", Gender: "feminine"}
+	if arg := say.sayArg("12.50", "en"); arg != "zh CURRENCY pronounced feminine 12.50" {
+		t.Fatalf("Say arg=%s", arg)
+	}
+
+	dateTime, _ := time.ParseInLocation("2006-01-02 15:04:05", "2026-10-19 08:30:00", time.Local)
+	say = SayPhrase{Type: "DATE_TIME"}
+	if arg := say.sayArg("2026-10-19 08:30:00", ""); arg != "en CURRENT_DATE_TIME pronounced "+strconv.FormatInt(dateTime.Unix(), 10) {
+		t.Fatalf("Say arg=%s", arg)
+	}
+	t.Log("Test pass.")
+}
+
+func TestDigitsFile(t *testing.T) {
+
+	path := eventsocket.Ivr_Sound_Path + Default_Digits_Path + "/"
+	expect := "file_string://" + path + "1.wav!" + path + "2.wav!" + path + "point.wav!" + path + "5.wav"
+	if file := digitsFile("12.5$", ""); file != expect {
+		t.Fatalf("Digits file=%s", file)
+	}
+	if file := digitsFile("abc", ""); file != "" {
+		t.Fatalf("Digits file=%s,expect empty", file)
+	}
+	t.Log("Test pass.")
+}
@@ -0,0 +1,443 @@
+// fs/ivr/ ScheduleNode
+
+package ivr
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const Schedule_State_Open string = "open"
+const Schedule_State_Closed string = "closed"
+const Schedule_State_Holiday string = "holiday"
+
+// Schedule_All overrides every schedule.
+const Schedule_All string = "*"
+
+const Schedule_Date_Layout string = "2006-01-02"
+const Schedule_Time_Layout string = "2006-01-02 15:04"
+
+var weekdays = map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday}
+
+// OpenHours opens Days from Open to Close (hh:mm),a Close before Open ends
+// on the next day,eg. 22:00-06:00.
+type OpenHours struct {
+	Days  string `xml:"days,attr"` // eg. mon-fri or sat,sun
+	Open  string `xml:"open,attr"`
+	Close string `xml:"close,attr"`
+
+	days    []time.Weekday
+	openAt  int // Minutes of the day.
+	closeAt int
+}
+
+type WeeklyHours struct {
+	Day []OpenHours
+}
+
+// Holiday closes the days From to To (yyyy-MM-dd),To defaults to From.
+type Holiday struct {
+	Name string `xml:"name,attr"`
+	From string `xml:"date,attr"`
+	To   string `xml:"to,attr"`
+}
+
+type Holidays struct {
+	Holiday []Holiday
+}
+
+// Closure closes from From to To (yyyy-MM-dd HH:mm),eg. for maintenance.
+type Closure struct {
+	Reason string `xml:"reason,attr"`
+	From   string `xml:"from,attr"`
+	To     string `xml:"to,attr"`
+
+	from time.Time
+	to   time.Time
+}
+
+type Closures struct {
+	Closure []Closure
+}
+
+// ScheduleNode routes by business hours in TimeZone.Holidays of the node and
+// of HolidayFile go to Holiday (Closed when empty),one-off Closures and the
+// time out of Hours go to Closed,else Open.The state is kept in call variable
+// schedule_state.The admin api forces a schedule closed by its node name.
+type ScheduleNode struct {
+	NodeName    string `xml:"name,attr"`
+	TimeZone    string // eg. Asia/Shanghai,default local time.
+	Hours       WeeklyHours
+	Holidays    Holidays
+	HolidayFile string // Lines of "yyyy-MM-dd [yyyy-MM-dd] name",# comments.
+	Closures    Closures
+	Open        string
+	Closed      string
+	Holiday     string
+
+	location *time.Location // Of TimeZone,set with the parsed values when loaded.
+}
+
+// scheduleNames are the ScheduleNodes of the loaded flow files.
+var scheduleNames map[string]bool = make(map[string]bool)
+
+var scheduleOverrides map[string]string = make(map[string]string)
+var scheduleOverrideMutex sync.RWMutex
+
+// SetScheduleOverride forces schedule name (Schedule_All for every schedule) closed.
+func SetScheduleOverride(name, state string) error {
+	if state != Schedule_State_Closed {
+		return errors.New("Schedule can only be forced " + Schedule_State_Closed)
+	}
+	scheduleOverrideMutex.Lock()
+	defer scheduleOverrideMutex.Unlock()
+	scheduleOverrides[name] = state
+	return nil
+}
+
+func isScheduleName(name string) bool {
+	scheduleOverrideMutex.RLock()
+	defer scheduleOverrideMutex.RUnlock()
+	return scheduleNames[name]
+}
+
+func ClearScheduleOverride(name string) {
+	scheduleOverrideMutex.Lock()
+	defer scheduleOverrideMutex.Unlock()
+	delete(scheduleOverrides, name)
+}
+
+// ScheduleOverrides returns a copy of the overrides.
+func ScheduleOverrides() map[string]string {
+	scheduleOverrideMutex.RLock()
+	defer scheduleOverrideMutex.RUnlock()
+	overrides := make(map[string]string, len(scheduleOverrides))
+	for name, state := range scheduleOverrides {
+		overrides[name] = state
+	}
+	return overrides
+}
+
+func scheduleOverride(name string) (string, bool) {
+	scheduleOverrideMutex.RLock()
+	defer scheduleOverrideMutex.RUnlock()
+	if state, ok := scheduleOverrides[name]; ok {
+		return state, true
+	}
+	state, ok := scheduleOverrides[Schedule_All]
+	return state, ok
+}
+
+type holidayFile struct {
+	modTime  time.Time
+	holidays []Holiday
+}
+
+var holidayFiles map[string]*holidayFile = make(map[string]*holidayFile)
+var holidayFileMutex sync.Mutex
+
+// loadHolidayFile reads the holidays of name,the file is read again when it
+// changes.
+func loadHolidayFile(name string) ([]Holiday, error) {
+
+	fileInfo, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	holidayFileMutex.Lock()
+	defer holidayFileMutex.Unlock()
+
+	if cached, ok := holidayFiles[name]; ok && cached.modTime.Equal(fileInfo.ModTime()) {
+		return cached.holidays, nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	holidays := make([]Holiday, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		holiday := Holiday{From: fields[0]}
+		fields = fields[1:]
+		if len(fields) > 0 {
+			if _, err := time.Parse(Schedule_Date_Layout, fields[0]); err == nil {
+				holiday.To = fields[0]
+				fields = fields[1:]
+			}
+		}
+		holiday.Name = strings.Join(fields, " ")
+		if _, err := time.Parse(Schedule_Date_Layout, holiday.From); err != nil {
+			return nil, errors.New("Invalid holiday " + line)
+		}
+		holidays = append(holidays, holiday)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	holidayFiles[name] = &holidayFile{modTime: fileInfo.ModTime(), holidays: holidays}
+	return holidays, nil
+}
+
+// parseDays returns the weekdays of days,eg. mon-fri,sun.
+func parseDays(days string) ([]time.Weekday, error) {
+	result := make([]time.Weekday, 0, 7)
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+		from, ok := weekdays[strings.TrimSpace(bounds[0])]
+		if !ok {
+			return nil, errors.New("Invalid days " + days)
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[strings.TrimSpace(bounds[1])]; !ok {
+				return nil, errors.New("Invalid days " + days)
+			}
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			result = append(result, day)
+			if day == to {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// parseClock returns the minutes of the day of hh:mm,24:00 is the end of the day.
+func parseClock(clock string) (int, error) {
+	if clock == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parse checks hours and keeps the parsed days and clocks.
+func (hours OpenHours) parse() (OpenHours, error) {
+	var err error
+	if hours.days, err = parseDays(hours.Days); err != nil {
+		return hours, err
+	}
+	if hours.openAt, err = parseClock(hours.Open); err != nil {
+		return hours, errors.New("Invalid open " + hours.Open)
+	}
+	if hours.closeAt, err = parseClock(hours.Close); err != nil {
+		return hours, errors.New("Invalid close " + hours.Close)
+	}
+	return hours, nil
+}
+
+func (hours OpenHours) isOpen(now time.Time) bool {
+
+	minute := now.Hour()*60 + now.Minute()
+	yesterday := (now.Weekday() + 6) % 7
+	for _, day := range hours.days {
+		if hours.closeAt > hours.openAt {
+			if day == now.Weekday() && minute >= hours.openAt && minute < hours.closeAt {
+				return true
+			}
+		} else {
+			// Overnight,from open of day to close of the next day.
+			if (day == now.Weekday() && minute >= hours.openAt) || (day == yesterday && minute < hours.closeAt) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// check validates the dates of holiday,valid dates compare as strings.
+func (holiday Holiday) check() error {
+	if _, err := time.Parse(Schedule_Date_Layout, holiday.From); err != nil {
+		return errors.New("Invalid holiday " + holiday.From)
+	}
+	if holiday.To == "" {
+		return nil
+	}
+	if _, err := time.Parse(Schedule_Date_Layout, holiday.To); err != nil || holiday.To < holiday.From {
+		return errors.New("Invalid holiday " + holiday.From + " to " + holiday.To)
+	}
+	return nil
+}
+
+func (holiday Holiday) contains(now time.Time) bool {
+	today := now.Format(Schedule_Date_Layout)
+	to := holiday.To
+	if to == "" {
+		to = holiday.From
+	}
+	return today >= holiday.From && today <= to
+}
+
+// parse checks closure and keeps its times in location.
+func (closure Closure) parse(location *time.Location) (Closure, error) {
+	var err error
+	if closure.from, err = time.ParseInLocation(Schedule_Time_Layout, closure.From, location); err != nil {
+		return closure, errors.New("Invalid closure from " + closure.From)
+	}
+	if closure.to, err = time.ParseInLocation(Schedule_Time_Layout, closure.To, location); err != nil || !closure.to.After(closure.from) {
+		return closure, errors.New("Invalid closure to " + closure.To)
+	}
+	return closure, nil
+}
+
+func (closure Closure) contains(now time.Time) bool {
+	return !now.Before(closure.from) && now.Before(closure.to)
+}
+
+// Load resolves a relative HolidayFile against dir of the flow file,like the
+// files of sub flows,checks the schedule and registers it for the admin api.
+func (node ScheduleNode) Load(dir string) (IVRNode, error) {
+	if node.HolidayFile != "" && !filepath.IsAbs(node.HolidayFile) {
+		node.HolidayFile = filepath.Join(dir, node.HolidayFile)
+	}
+	node, err := node.parse()
+	if err != nil {
+		return nil, err
+	}
+	if node.HolidayFile != "" {
+		if _, err = loadHolidayFile(node.HolidayFile); err != nil {
+			return nil, err
+		}
+	}
+	scheduleOverrideMutex.Lock()
+	defer scheduleOverrideMutex.Unlock()
+	scheduleNames[node.NodeName] = true
+	return node, nil
+}
+
+// parse checks the time zone,hours,holidays and closures of node and keeps
+// the parsed values.
+func (node ScheduleNode) parse() (ScheduleNode, error) {
+
+	location := time.Local
+	if node.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(node.TimeZone); err != nil {
+			return node, err
+		}
+	}
+
+	hours := make([]OpenHours, len(node.Hours.Day))
+	for i, day := range node.Hours.Day {
+		var err error
+		if hours[i], err = day.parse(); err != nil {
+			return node, err
+		}
+	}
+
+	for _, holiday := range node.Holidays.Holiday {
+		if err := holiday.check(); err != nil {
+			return node, err
+		}
+	}
+
+	closures := make([]Closure, len(node.Closures.Closure))
+	for i, closure := range node.Closures.Closure {
+		var err error
+		if closures[i], err = closure.parse(location); err != nil {
+			return node, err
+		}
+	}
+
+	node.Hours.Day = hours
+	node.Closures.Closure = closures
+	node.location = location
+	return node, nil
+}
+
+// state returns the state of the schedule at now and why.
+func (node ScheduleNode) state(now time.Time) (string, string, error) {
+
+	if state, ok := scheduleOverride(node.NodeName); ok {
+		return state, "admin override", nil
+	}
+
+	// A node not loaded from a flow file is parsed here.
+	if node.location == nil {
+		var err error
+		if node, err = node.parse(); err != nil {
+			return "", "", err
+		}
+	}
+	now = now.In(node.location)
+
+	holidays := node.Holidays.Holiday
+	if node.HolidayFile != "" {
+		fileHolidays, err := loadHolidayFile(node.HolidayFile)
+		if err != nil {
+			return "", "", err
+		}
+		holidays = append(append([]Holiday{}, holidays...), fileHolidays...)
+	}
+	for _, holiday := range holidays {
+		if holiday.contains(now) {
+			return Schedule_State_Holiday, holiday.Name, nil
+		}
+	}
+
+	for _, closure := range node.Closures.Closure {
+		if closure.contains(now) {
+			return Schedule_State_Closed, closure.Reason, nil
+		}
+	}
+
+	for _, hours := range node.Hours.Day {
+		if hours.isOpen(now) {
+			return Schedule_State_Open, hours.Days, nil
+		}
+	}
+	return Schedule_State_Closed, "out of hours", nil
+}
+
+func (node ScheduleNode) branch(state string) string {
+	switch state {
+	case Schedule_State_Open:
+		return node.Open
+	case Schedule_State_Holiday:
+		if node.Holiday != "" {
+			return node.Holiday
+		}
+	}
+	return node.Closed
+}
+
+func (node ScheduleNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	state, reason, err := node.state(time.Now())
+	if err != nil {
+		// Better closed than open with nobody to answer.
+		ivrChannel.Log.Warn("Schedule failure for %s", err.Error())
+		state, reason = Schedule_State_Closed, "invalid schedule"
+	}
+	ivrChannel.Vars.Set("schedule_state", state)
+	ivrChannel.Log.Info("Schedule %s is %s (%s)", node.NodeName, state, reason)
+
+	return node.branch(state), nil
+}
@@ -0,0 +1,167 @@
+// ScheduleNode test
+
+package ivr
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScheduleNode(t *testing.T) {
+
+	holidayFile := filepath.Join(t.TempDir(), "holidays.txt")
+	content := "# China 2026\n2026-10-01 2026-10-07 National Day\n\n2026-12-25 Christmas\n"
+	if err := ioutil.WriteFile(holidayFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	node := ScheduleNode{
+		NodeName: "businessHours",
+		TimeZone: "Asia/Shanghai",
+		Hours: WeeklyHours{Day: []OpenHours{
+			{Days: "mon-fri", Open: "09:00", Close: "18:00"},
+			{Days: "sat", Open: "22:00", Close: "02:00"},
+		}},
+		Holidays:    Holidays{Holiday: []Holiday{{Name: "New Year", From: "2027-01-01"}}},
+		HolidayFile: holidayFile,
+		Closures:    Closures{Closure: []Closure{{Reason: "Maintenance", From: "2026-10-21 14:00", To: "2026-10-21 16:00"}}},
+		Open:        "mainMenu",
+		Closed:      "closedAnn",
+	}
+
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("No time zone database")
+	}
+
+	tests := []struct {
+		time  string
+		state string
+	}{
+		{"2026-10-19 09:00", Schedule_State_Open},    // Monday
+		{"2026-10-19 08:59", Schedule_State_Closed},  // Monday
+		{"2026-10-23 17:59", Schedule_State_Open},    // Friday
+		{"2026-10-23 18:00", Schedule_State_Closed},  // Friday
+		{"2026-10-24 12:00", Schedule_State_Closed},  // Saturday
+		{"2026-10-24 23:30", Schedule_State_Open},    // Saturday night
+		{"2026-10-25 01:59", Schedule_State_Open},    // Sunday,after saturday night
+		{"2026-10-25 02:00", Schedule_State_Closed},  // Sunday
+		{"2026-10-21 15:00", Schedule_State_Closed},  // Maintenance
+		{"2026-10-21 16:00", Schedule_State_Open},    // After maintenance
+		{"2026-10-05 10:00", Schedule_State_Holiday}, // National Day
+		{"2026-12-25 10:00", Schedule_State_Holiday}, // Christmas
+		{"2027-01-01 10:00", Schedule_State_Holiday}, // New Year
+	}
+	for _, test := range tests {
+		now, _ := time.ParseInLocation(Schedule_Time_Layout, test.time, shanghai)
+		state, reason, err := node.state(now.In(time.UTC))
+		if err != nil || state != test.state {
+			t.Fatalf("State at %s=%s(%s),err=%v,expect %s", test.time, state, reason, err, test.state)
+		}
+	}
+
+	if node.branch(Schedule_State_Holiday) != "closedAnn" {
+		t.Fatalf("Holiday branch=%s,expect Closed closedAnn", node.branch(Schedule_State_Holiday))
+	}
+
+	monday, _ := time.ParseInLocation(Schedule_Time_Layout, "2026-10-19 10:00", shanghai)
+	if err := SetScheduleOverride(Schedule_All, Schedule_State_Open); err == nil {
+		t.Fatal("Force open,expect error")
+	}
+	SetScheduleOverride(Schedule_All, Schedule_State_Closed)
+	if state, _, _ := node.state(monday); state != Schedule_State_Closed {
+		t.Fatalf("State forced closed=%s,expect closed", state)
+	}
+	ClearScheduleOverride(Schedule_All)
+	SetScheduleOverride("otherHours", Schedule_State_Closed)
+	defer ClearScheduleOverride("otherHours")
+	if state, _, _ := node.state(monday); state != Schedule_State_Open {
+		t.Fatalf("State with other override=%s,expect open", state)
+	}
+
+	node.Holidays.Holiday[0].From = "2027-1-1"
+	if _, _, err := node.state(monday); err == nil {
+		t.Fatal("Invalid holiday,expect error")
+	}
+	node.Holidays.Holiday[0].From = "2027-01-01"
+
+	node.Hours.Day[0].Days = "monday"
+	if _, _, err := node.state(monday); err == nil {
+		t.Fatal("Invalid days,expect error")
+	}
+
+	t.Log("Test pass.")
+}
+
+func TestScheduleNodeLoad(t *testing.T) {
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "holidays.txt"), []byte("2026-10-01 2026-10-07 National Day\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	node := ScheduleNode{NodeName: "loadedHours", TimeZone: "UTC", HolidayFile: "holidays.txt",
+		Hours:    WeeklyHours{Day: []OpenHours{{Days: "mon-fri", Open: "09:00", Close: "18:00"}}},
+		Holidays: Holidays{Holiday: []Holiday{{Name: "New Year", From: "2027-01-01"}}},
+		Closures: Closures{Closure: []Closure{{Reason: "Maintenance", From: "2026-10-21 14:00", To: "2026-10-21 16:00"}}},
+		Open:     "mainMenu", Closed: "closedAnn"}
+	loaded, err := node.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file := loaded.(ScheduleNode).HolidayFile; file != filepath.Join(dir, "holidays.txt") {
+		t.Fatalf("HolidayFile=%s,expect relative to the flow file", file)
+	}
+	monday, _ := time.Parse(Schedule_Time_Layout, "2026-10-19 10:00")
+	if state, _, err := loaded.(ScheduleNode).state(monday); err != nil || state != Schedule_State_Open {
+		t.Fatalf("Loaded state=%s,err=%v,expect open", state, err)
+	}
+
+	// A bad schedule fails the flow file instead of closing every call.
+	invalid := []func(node *ScheduleNode){
+		func(node *ScheduleNode) { node.TimeZone = "Asia/Shanghia" },
+		func(node *ScheduleNode) { node.Hours.Day[0].Days = "mon-fir" },
+		func(node *ScheduleNode) { node.Hours.Day[0].Close = "18:60" },
+		func(node *ScheduleNode) { node.Holidays.Holiday[0].From = "2027-1-1" },
+		func(node *ScheduleNode) { node.Holidays.Holiday[0].To = "2026-12-31" },
+		func(node *ScheduleNode) { node.Closures.Closure[0].To = "2026-10-21" },
+		func(node *ScheduleNode) { node.HolidayFile = "missing.txt" },
+	}
+	for i, change := range invalid {
+		bad := node
+		bad.Hours = WeeklyHours{Day: append([]OpenHours{}, node.Hours.Day...)}
+		bad.Holidays = Holidays{Holiday: append([]Holiday{}, node.Holidays.Holiday...)}
+		bad.Closures = Closures{Closure: append([]Closure{}, node.Closures.Closure...)}
+		change(&bad)
+		if _, err := bad.Load(dir); err == nil {
+			t.Fatalf("Load invalid schedule %d,expect error", i)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"loadedHours", http.StatusOK},
+		{Schedule_All, http.StatusOK},
+		{"loadedHour", http.StatusNotFound},
+		{"", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/schedule", nil)
+		r.Form = url.Values{"name": {test.name}}
+		handleAdminSchedule(w, r)
+		ClearScheduleOverride(test.name)
+		if w.Code != test.status {
+			t.Fatalf("Force %s status=%d,expect %d", test.name, w.Code, test.status)
+		}
+	}
+
+	t.Log("Test pass.")
+}
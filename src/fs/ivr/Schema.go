@@ -0,0 +1,252 @@
+// fs/ivr/ Schema
+
+package ivr
+
+import (
+	l4g "code.google.com/p/log4go"
+	"database/sql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"strings"
+	"time"
+)
+
+var incompatibleSchemaErr error = errors.New("Incompatible schema,run migrate first")
+
+// Migration moves the schema from Version-1 to Version.Statements are written
+// for mysql,the types are translated for the other databases by ddl.MySQL
+// commits every DDL statement,so a failed migration may be half applied and
+// the statements must be safe to run again : tables are created if not exists
+// and a statement failing on an existing index or column is skipped.
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+var migrations = []Migration{
+	{1, "Create call record tables", []string{
+		`create table if not exists IvrCdr (
+			CallId varchar(64) not null primary key,
+			ChannelId varchar(64) not null,
+			Flow varchar(64) not null,
+			ANI varchar(64) not null,
+			DNIS varchar(64) not null,
+			StartTime datetime not null,
+			AnswerTime datetime null,
+			EndTime datetime not null,
+			HangupCause varchar(64) not null,
+			Outcome varchar(32) not null,
+			NoInputTimes int not null,
+			NoMatchTimes int not null)`,
+		`create table if not exists IvrNodeVisit (
+			CallId varchar(64) not null,
+			Seq int not null,
+			Node varchar(64) not null,
+			EnterTime datetime not null,
+			LeaveTime datetime null,
+			Result varchar(255) not null,
+			primary key (CallId, Seq))`,
+		`create table if not exists IvrInput (
+			CallId varchar(64) not null,
+			Seq int not null,
+			Node varchar(64) not null,
+			Value varchar(255) not null,
+			Sensitive boolean not null,
+			Result varchar(32) not null,
+			InputTime datetime not null,
+			primary key (CallId, Seq))`,
+	}},
+	{2, "Index call records for reports", []string{
+		`create index IdxIvrCdrStart on IvrCdr (StartTime, Flow)`,
+		`create index IdxIvrNodeVisitNode on IvrNodeVisit (Node)`,
+		`create index IdxIvrInputNode on IvrInput (Node, Result)`,
+	}},
+	{3, "Create recording table", []string{
+		`create table if not exists IvrRecording (
+			CallId varchar(64) not null,
+			Seq int not null,
+			Node varchar(64) not null,
+			Path varchar(255) not null,
+			Duration int not null,
+			Reason varchar(32) not null,
+			RecordTime datetime not null,
+			primary key (CallId, Seq))`,
+	}},
+}
+
+// Schema_Version is the schema version this server reads and writes.
+var Schema_Version int = migrations[len(migrations)-1].Version
+
+const schemaVersionTable string = `create table if not exists IvrSchemaVersion (
+	Version int not null primary key,
+	Description varchar(255) not null,
+	AppliedTime datetime not null)`
+
+// ddl translates a mysql statement for DBType.
+func (persistor *DBPersistor) ddl(statement string) string {
+	if persistor.DBType == DB_Type_Postgres {
+		return strings.Replace(statement, "datetime", "timestamp", -1)
+	}
+	return statement
+}
+
+func (persistor *DBPersistor) hasVersionTable() bool {
+	rows, err := persistor.DB.Query("select Version from IvrSchemaVersion where 1=0")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+// SchemaVersion returns the applied schema version,0 for an empty database.
+func (persistor *DBPersistor) SchemaVersion() (int, error) {
+
+	if !persistor.hasVersionTable() {
+		return 0, nil
+	}
+
+	var version sql.NullInt64
+	err := persistor.DB.QueryRow("select max(Version) from IvrSchemaVersion").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// CheckSchema refuses a database whose schema is not Schema_Version.
+func (persistor *DBPersistor) CheckSchema() error {
+
+	version, err := persistor.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version != Schema_Version {
+		l4g.Error("Database schema version is %d,server needs %d", version, Schema_Version)
+		return incompatibleSchemaErr
+	}
+	return nil
+}
+
+// Migrate applies the pending migrations and returns the new schema version.
+func (persistor *DBPersistor) Migrate() (int, error) {
+
+	version, err := persistor.SchemaVersion()
+	if err != nil {
+		return version, err
+	}
+
+	if version > Schema_Version {
+		l4g.Error("Database schema version %d is newer than %d,upgrade the server", version, Schema_Version)
+		return version, incompatibleSchemaErr
+	}
+
+	if !persistor.hasVersionTable() {
+		if _, err = persistor.DB.Exec(persistor.ddl(schemaVersionTable)); err != nil {
+			return version, err
+		}
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			continue
+		}
+
+		l4g.Info("Migrate schema to version %d : %s", migration.Version, migration.Description)
+		tx, err := persistor.DB.Begin()
+		if err != nil {
+			return version, err
+		}
+		for _, statement := range migration.Statements {
+			if err = persistor.execMigration(tx, migration, statement); err != nil {
+				tx.Rollback()
+				return version, err
+			}
+		}
+		_, err = tx.Exec(persistor.rebind("insert into IvrSchemaVersion(Version,Description,AppliedTime) values(?,?,?)"),
+			migration.Version, migration.Description, time.Now())
+		if err != nil {
+			tx.Rollback()
+			return version, err
+		}
+		if err = tx.Commit(); err != nil {
+			return version, err
+		}
+		version = migration.Version
+	}
+
+	return version, nil
+}
+
+// execMigration runs statement of migration in tx,a statement failing on an
+// existing table,index or column is skipped.Postgres aborts the transaction
+// on a failed statement,so there the statement runs in a savepoint.
+func (persistor *DBPersistor) execMigration(tx *sql.Tx, migration Migration, statement string) error {
+
+	savepoint := persistor.DBType == DB_Type_Postgres
+	if savepoint {
+		if _, err := tx.Exec("savepoint IvrMigration"); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(persistor.ddl(statement))
+	if err != nil && !isExistsError(err) {
+		return err
+	}
+	if err != nil {
+		l4g.Warn("Skip applied statement of version %d for %s", migration.Version, err.Error())
+	}
+
+	if savepoint {
+		if err != nil {
+			_, err = tx.Exec("rollback to savepoint IvrMigration")
+		} else {
+			_, err = tx.Exec("release savepoint IvrMigration")
+		}
+		return err
+	}
+	return nil
+}
+
+// isExistsError reports whether a DDL statement failed on an existing table,
+// index or column,ie. it was applied by a former failed migration.
+func isExistsError(err error) bool {
+	switch dbErr := err.(type) {
+	case *mysql.MySQLError:
+		// Table exists,duplicate column,duplicate index.
+		return dbErr.Number == 1050 || dbErr.Number == 1060 || dbErr.Number == 1061
+	case sqlite3.Error:
+		return strings.Contains(dbErr.Error(), "already exists") || strings.Contains(dbErr.Error(), "duplicate column")
+	case *pq.Error:
+		// Duplicate table or index,duplicate column.
+		return dbErr.Code == "42P07" || dbErr.Code == "42701"
+	}
+	return false
+}
+
+// Migrate migrates the database of the server config.
+func Migrate() (int, error) {
+	return MigrateStore(serverConfig.Persistence)
+}
+
+// MigrateStore migrates the database selected by config.
+func MigrateStore(config Persistence) (int, error) {
+
+	config = config.withDefaults()
+
+	persistor := newConfigDBPersistor(config)
+	if persistor == nil {
+		return 0, errors.New("No schema for persistence type " + config.Type)
+	}
+
+	if err := persistor.connect(); err != nil {
+		return 0, err
+	}
+	defer persistor.Close()
+
+	return persistor.Migrate()
+}
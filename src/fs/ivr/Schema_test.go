@@ -0,0 +1,186 @@
+// Schema test
+
+package ivr
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/lib/pq"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateSQLite(t *testing.T) {
+
+	config := Persistence{Type: Persist_Type_SQLite, DBName: filepath.Join(t.TempDir(), "ivr.db")}
+
+	persistor := newConfigDBPersistor(config)
+	if err := persistor.Open(); err != incompatibleSchemaErr {
+		t.Fatalf("Open empty database err=%v,expect %v", err, incompatibleSchemaErr)
+	}
+	persistor.Close()
+
+	for i := 0; i < 2; i++ {
+		if version, err := MigrateStore(config); err != nil || version != Schema_Version {
+			t.Fatalf("Migrate version=%d,err=%v", version, err)
+		}
+	}
+
+	persistor = newConfigDBPersistor(config)
+	if err := persistor.Open(); err != nil {
+		t.Fatalf("Open migrated database failure for %s", err.Error())
+	}
+	defer persistor.Close()
+
+	record := NewCallRecord(time.Now())
+	record.CallId = "call-1"
+	record.EnterNode("root")
+	record.LeaveNode("welcome")
+	record.AddInput(CollectedInput{Node: "pwdService", Value: "******", Sensitive: true, Result: Input_Match})
+	record.AddRecording(Recording{Node: "voiceMail", Path: "/tmp/call-1.wav", Duration: 3200, Reason: Record_Reason_Terminator, RecordTime: time.Now()})
+	if err := persistor.WriteRecords([]*CallRecord{record}); err != nil {
+		t.Fatalf("WriteRecords failure for %s", err.Error())
+	}
+
+	var visits, inputs, recordings int
+	persistor.DB.QueryRow("select count(*) from IvrNodeVisit where CallId=?", "call-1").Scan(&visits)
+	persistor.DB.QueryRow("select count(*) from IvrInput where CallId=?", "call-1").Scan(&inputs)
+	persistor.DB.QueryRow("select count(*) from IvrRecording where CallId=?", "call-1").Scan(&recordings)
+	if visits != 1 || inputs != 1 || recordings != 1 {
+		t.Fatalf("Written visits=%d,inputs=%d,recordings=%d", visits, inputs, recordings)
+	}
+	t.Log("Test pass.")
+}
+
+func TestMigrateHalfApplied(t *testing.T) {
+
+	config := Persistence{Type: Persist_Type_SQLite, DBName: filepath.Join(t.TempDir(), "ivr.db")}
+
+	// A migration failing in the middle on mysql leaves its DDL behind.
+	persistor := newConfigDBPersistor(config)
+	if err := persistor.connect(); err != nil {
+		t.Fatalf("Connect failure for %s", err.Error())
+	}
+	for _, statement := range []string{schemaVersionTable, migrations[0].Statements[0], migrations[1].Statements[0]} {
+		if _, err := persistor.DB.Exec(statement); err != nil {
+			t.Fatalf("Exec %s failure for %s", statement, err.Error())
+		}
+	}
+	persistor.Close()
+
+	if version, err := MigrateStore(config); err != nil || version != Schema_Version {
+		t.Fatalf("Migrate half applied version=%d,err=%v", version, err)
+	}
+	t.Log("Test pass.")
+}
+
+// fakePostgres aborts the transaction on a failed statement like postgres,
+// the index IdxIvrCdrStart is left by a half applied version 2.
+type fakePostgres struct {
+	versions []int64
+}
+
+type fakePostgresConn struct {
+	server  *fakePostgres
+	aborted bool
+}
+
+type fakePostgresStmt struct {
+	conn  *fakePostgresConn
+	query string
+}
+
+type fakePostgresRows struct {
+	values []int64
+}
+
+func (server *fakePostgres) Open(name string) (driver.Conn, error) {
+	return &fakePostgresConn{server: server}, nil
+}
+
+func (conn *fakePostgresConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakePostgresStmt{conn, query}, nil
+}
+
+func (conn *fakePostgresConn) Close() error              { return nil }
+func (conn *fakePostgresConn) Begin() (driver.Tx, error) { return conn, nil }
+func (conn *fakePostgresConn) Rollback() error           { conn.aborted = false; return nil }
+
+func (conn *fakePostgresConn) Commit() error {
+	if conn.aborted {
+		conn.aborted = false
+		return &pq.Error{Code: "25P02", Message: "current transaction is aborted"}
+	}
+	return nil
+}
+
+func (stmt *fakePostgresStmt) Close() error  { return nil }
+func (stmt *fakePostgresStmt) NumInput() int { return -1 }
+
+func (stmt *fakePostgresStmt) Exec(args []driver.Value) (driver.Result, error) {
+	conn := stmt.conn
+	switch {
+	case strings.HasPrefix(stmt.query, "rollback to savepoint"):
+		conn.aborted = false
+	case conn.aborted:
+		return nil, &pq.Error{Code: "25P02", Message: "current transaction is aborted"}
+	case strings.HasPrefix(stmt.query, "create index IdxIvrCdrStart"):
+		conn.aborted = true
+		return nil, &pq.Error{Code: "42P07", Message: "relation IdxIvrCdrStart already exists"}
+	case strings.HasPrefix(stmt.query, "insert into IvrSchemaVersion"):
+		conn.server.versions = append(conn.server.versions, args[0].(int64))
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (stmt *fakePostgresStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if strings.Contains(stmt.query, "max(Version)") {
+		version := int64(0)
+		for _, v := range stmt.conn.server.versions {
+			if v > version {
+				version = v
+			}
+		}
+		return &fakePostgresRows{[]int64{version}}, nil
+	}
+	return &fakePostgresRows{}, nil
+}
+
+func (rows *fakePostgresRows) Columns() []string { return []string{"Version"} }
+func (rows *fakePostgresRows) Close() error      { return nil }
+
+func (rows *fakePostgresRows) Next(dest []driver.Value) error {
+	if len(rows.values) == 0 {
+		return io.EOF
+	}
+	dest[0], rows.values = rows.values[0], rows.values[1:]
+	return nil
+}
+
+func TestMigrateHalfAppliedPostgres(t *testing.T) {
+
+	server := &fakePostgres{versions: []int64{1}}
+	sql.Register("ivrfakepostgres", server)
+	db, err := sql.Open("ivrfakepostgres", "")
+	if err != nil {
+		t.Fatalf("Open fake postgres failure for %s", err.Error())
+	}
+	db.SetMaxOpenConns(1)
+
+	persistor := &DBPersistor{DB: db, DBType: DB_Type_Postgres}
+	if version, err := persistor.Migrate(); err != nil || version != Schema_Version {
+		t.Fatalf("Migrate half applied version=%d,err=%v", version, err)
+	}
+	if len(server.versions) != 3 || server.versions[1] != 2 || server.versions[2] != 3 {
+		t.Fatalf("Applied versions=%v,expect [1 2 3]", server.versions)
+	}
+
+	if !isExistsError(&pq.Error{Code: "42P07"}) || isExistsError(errors.New("42P07")) {
+		t.Fatal("isExistsError of postgres")
+	}
+	t.Log("Test pass.")
+}
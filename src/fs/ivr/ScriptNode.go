@@ -0,0 +1,332 @@
+// fs/ivr/ ScriptNode
+
+package ivr
+
+import (
+	"context"
+	"errors"
+	"fs/ivr/eventsocket"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const Default_Script_Timeout int = 2000
+const Script_Call_Stack_Size int = 120
+const Script_Registry_Max_Size int = 256 * 1024
+const Script_String_Max_Len int = 64 * 1024
+
+// Functions of the base library a script must not use,they read files,load
+// code or touch the interpreter.
+var scriptUnsafeFuncs = []string{"dofile", "loadfile", "load", "loadstring", "require", "module",
+	"collectgarbage", "getfenv", "setfenv", "_printregs"}
+
+// ScriptNode runs a Lua script in a sandbox,only the base,table,string and
+// math libraries are opened.The script reads and writes call variables with
+// the vars table (vars.ANI,vars.level = "vip") and talks to the caller with
+// the esl table :
+//
+//	esl.play(prompt)                                             plays a prompt or a sound file
+//	esl.collect(prompt,maxLen,timeoutMs,terminator,sensitive)    returns the digits,"" on timeout
+//	esl.set(name,value)                                          sets a call and channel variable
+//
+// Sensitive digits,eg. a PIN,are masked out of the logs.The script returns
+// the name of the next node,NextNode when it returns nothing.A script failing
+// or running longer than Timeout (the time of play and collect counts,a prompt
+// playing at the deadline is broken) goes to OnError.
+type ScriptNode struct {
+	NodeName string `xml:"name,attr"`
+	Script   string // Lua source,or
+	File     string // a Lua file,relative to the flow file.
+	Timeout  int    // Millisecond.
+	NextNode string
+	OnError  string
+
+	proto *lua.FunctionProto // Compiled when the flow file is loaded.
+}
+
+// Load resolves File against dir of the flow file and compiles the script,
+// a syntax error fails the flow file.
+func (node ScriptNode) Load(dir string) (IVRNode, error) {
+	if node.File != "" && !filepath.IsAbs(node.File) {
+		node.File = filepath.Join(dir, node.File)
+	}
+	source, err := node.source()
+	if err != nil {
+		return nil, err
+	}
+	if node.proto, err = compileScript(source, node.NodeName); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func compileScript(source, name string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, name)
+}
+
+func (node ScriptNode) source() (string, error) {
+	if node.File == "" {
+		return node.Script, nil
+	}
+	content, err := ioutil.ReadFile(node.File)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// newScriptState returns a Lua state with the safe libraries.
+func newScriptState() *lua.LState {
+
+	L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: Script_Call_Stack_Size, RegistryMaxSize: Script_Registry_Max_Size})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range scriptUnsafeFuncs {
+		L.SetGlobal(name, lua.LNil)
+	}
+	// string.rep allocates in one step,the deadline cannot stop it.
+	if strlib, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		L.SetField(strlib, "rep", L.NewFunction(scriptStringRep))
+	}
+	return L
+}
+
+// scriptStringRep is string.rep with results up to Script_String_Max_Len.
+func scriptStringRep(L *lua.LState) int {
+	str := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n <= 0 || str == "" {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if n > Script_String_Max_Len/len(str) {
+		L.RaiseError("string.rep result is longer than %d", Script_String_Max_Len)
+	}
+	L.Push(lua.LString(strings.Repeat(str, n)))
+	return 1
+}
+
+// openScriptVars makes table vars of L read and write the call variables.
+func openScriptVars(L *lua.LState, vars *CallVars) {
+
+	meta := L.NewTable()
+	L.SetField(meta, "__index", L.NewFunction(func(L *lua.LState) int {
+		if value, ok := vars.Lookup(L.CheckString(2)); ok {
+			L.Push(lua.LString(value))
+		} else {
+			L.Push(lua.LNil)
+		}
+		return 1
+	}))
+	L.SetField(meta, "__newindex", L.NewFunction(func(L *lua.LState) int {
+		vars.Set(L.CheckString(2), L.ToStringMeta(L.CheckAny(3)).String())
+		return 0
+	}))
+
+	table := L.NewTable()
+	L.SetMetatable(table, meta)
+	L.SetGlobal("vars", table)
+}
+
+// openScriptESL opens table esl of L on ivrChannel,the functions fail once
+// ctx is done.
+func openScriptESL(L *lua.LState, ctx context.Context, ivrChannel *IVRChannel) {
+
+	check := func(L *lua.LState) {
+		if ctx.Err() != nil {
+			L.RaiseError("script timeout")
+		}
+	}
+
+	esl := L.NewTable()
+	L.SetField(esl, "play", L.NewFunction(func(L *lua.LState) int {
+		check(L)
+		done, err := ivrChannel.PlayPrompt(L.CheckString(1))
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		L.Push(lua.LBool(done))
+		return 1
+	}))
+	L.SetField(esl, "collect", L.NewFunction(func(L *lua.LState) int {
+		check(L)
+		prompt := L.OptString(1, "")
+		maxLen := L.OptInt(2, Max_DTMF_Length)
+		timeoutMs := L.OptInt(3, Default_Review_Timeout)
+		terminator := L.OptString(4, "#")
+		sensitive := L.OptBool(5, false)
+		digits, err := ivrChannel.CollectDtmf(ctx, prompt, maxLen, timeoutMs, terminator, sensitive)
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		L.Push(lua.LString(digits))
+		return 1
+	}))
+	L.SetField(esl, "set", L.NewFunction(func(L *lua.LState) int {
+		check(L)
+		name, value := L.CheckString(1), L.ToStringMeta(L.CheckAny(2)).String()
+		ivrChannel.Vars.Set(name, value)
+		if err := ivrChannel.Esocket.SetVar(name, value); err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		return 0
+	}))
+	L.SetGlobal("esl", esl)
+}
+
+// PlayPrompt plays a prompt of the flow,or a sound file of the library.
+func (ivrChannel *IVRChannel) PlayPrompt(name string) (bool, error) {
+	name = ivrChannel.Vars.Expand(name)
+	if prompt, ok := ivrPromptMap[name]; ok {
+		return prompt.play(ivrChannel)
+	}
+	return ivrChannel.playback(soundFile(name, ivrChannel.language()))
+}
+
+// CollectDtmf plays prompt and collects up to maxLen digits until terminator
+// or timeoutMs without a digit,sensitive digits are masked out of the logs.
+// It fails when ctx is done,eg. at the deadline of a script.
+func (ivrChannel *IVRChannel) CollectDtmf(ctx context.Context, prompt string, maxLen, timeoutMs int, terminator string, sensitive bool) (string, error) {
+
+	ivrChannel.setSensitiveInput(sensitive)
+	defer ivrChannel.setSensitiveInput(false)
+
+	// Clear dtmf channel value.
+	for len(ivrChannel.Dtmf) > 0 {
+		<-ivrChannel.Dtmf
+	}
+
+	if prompt != "" {
+		if _, err := ivrChannel.PlayPrompt(prompt); err != nil {
+			return "", err
+		}
+	}
+
+	ivrChannel.Esocket.StartDTMF()
+	defer ivrChannel.Esocket.StopDTMF()
+
+	dtmfValue := ""
+	defer func() {
+		if sensitive {
+			ivrChannel.Esocket.AddSecret(dtmfValue)
+		}
+	}()
+	for len(dtmfValue) < maxLen && timeoutMs > 0 {
+		timeout := eventsocket.CheckTimeout(timeoutMs)
+		select {
+		case <-timeout:
+			return dtmfValue, nil
+		case dtmf := <-ivrChannel.Dtmf:
+			if dtmf == terminator {
+				return dtmfValue, nil
+			}
+			dtmfValue = dtmfValue + dtmf
+		case <-ivrChannel.ChannelHangup:
+			ivrChannel.Log.Trace("Channel hangup.")
+			return "", errors.New("Channel hangup.")
+		case <-ctx.Done():
+			return dtmfValue, ctx.Err()
+		}
+	}
+	return dtmfValue, nil
+}
+
+// run runs the script and returns the node it names.
+func (node ScriptNode) run(ivrChannel *IVRChannel) (string, error) {
+
+	proto := node.proto
+	if proto == nil {
+		source, err := node.source()
+		if err != nil {
+			return "", err
+		}
+		if proto, err = compileScript(source, node.NodeName); err != nil {
+			return "", err
+		}
+	}
+
+	timeoutMs := node.Timeout
+	if timeoutMs <= 0 {
+		timeoutMs = Default_Script_Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutMs)*time.Millisecond)
+	defer cancel()
+
+	L := newScriptState()
+	defer L.Close()
+	L.SetContext(ctx)
+
+	// A prompt playing at the deadline is broken.
+	ivrChannel.cancelPlay = ctx.Done()
+	defer func() { ivrChannel.cancelPlay = nil }()
+
+	openScriptVars(L, ivrChannel.Vars)
+	openScriptESL(L, ctx, ivrChannel)
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		args := make([]string, 0, L.GetTop())
+		for i := 1; i <= L.GetTop(); i++ {
+			args = append(args, L.ToStringMeta(L.Get(i)).String())
+		}
+		ivrChannel.Log.Info("Script %s", strings.Join(args, " "))
+		return 0
+	}))
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		if ctx.Err() != nil {
+			return "", errors.New("script timeout after " + (time.Duration(timeoutMs) * time.Millisecond).String())
+		}
+		return "", err
+	}
+
+	next := L.Get(-1)
+	L.Pop(1)
+	if next == lua.LNil {
+		return node.NextNode, nil
+	}
+	if nextNode, ok := next.(lua.LString); ok && nextNode != "" {
+		return string(nextNode), nil
+	}
+	return "", errors.New("script returns " + next.Type().String() + ",expect the name of a node")
+}
+
+func (node ScriptNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	start := time.Now()
+	nextNode, err := node.run(ivrChannel)
+	if err != nil {
+		if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+			return "", err
+		}
+		ivrChannel.Log.Warn("Script failure for %s", err.Error())
+		return node.OnError, nil
+	}
+
+	ivrChannel.Log.Debug("Script done in %dms,nextNode=%s", time.Since(start)/time.Millisecond, nextNode)
+	return nextNode, nil
+}
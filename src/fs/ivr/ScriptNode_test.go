@@ -0,0 +1,229 @@
+// ScriptNode test
+
+package ivr
+
+import (
+	"bufio"
+	"fmt"
+	"fs/ivr/calllog"
+	"fs/ivr/eventsocket"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScriptNode(t *testing.T) {
+
+	ivrChannel := &IVRChannel{Vars: NewCallVars(), Log: calllog.New()}
+	ivrChannel.Vars.Set("ANI", "13800138000")
+	ivrChannel.Vars.Set("balance", "1024.5")
+
+	node := ScriptNode{NodeName: "score", NextNode: "mainMenu", OnError: "toAgent", Script: `
+		local score = 0
+		if string.sub(vars.ANI, 1, 3) == "138" then score = score + 1 end
+		if tonumber(vars.balance) > 1000 then score = score + 2 end
+		vars.score = score
+		vars.missing = vars.noSuchVar == nil and "nil" or "set"
+		if score >= 3 then return "vipService" end`}
+	if next, err := node.Execute(ivrChannel); err != nil || next != "vipService" {
+		t.Fatalf("Execute=%s,err=%v,expect vipService", next, err)
+	}
+	if ivrChannel.Vars.Get("score") != "3" || ivrChannel.Vars.Get("missing") != "nil" {
+		t.Fatalf("score=%s,missing=%s,expect 3,nil", ivrChannel.Vars.Get("score"), ivrChannel.Vars.Get("missing"))
+	}
+
+	ivrChannel.Vars.Set("balance", "10")
+	if next, _ := node.Execute(ivrChannel); next != "mainMenu" {
+		t.Fatalf("Execute=%s,expect NextNode mainMenu", next)
+	}
+
+	tests := []struct {
+		script string
+		next   string
+	}{
+		{`return (os == nil and io == nil and dofile == nil and load == nil and require == nil) and "safe" or "unsafe"`, "safe"},
+		{`error("bad account")`, "toAgent"},
+		{`return 42`, "toAgent"},
+		{`return "unclosed`, "toAgent"},
+		{`print("score", vars.score) return ""`, "toAgent"},
+		{`return string.rep("ab", 3) == "ababab" and ("-"):rep(0) == "" and "rep" or "bad"`, "rep"},
+		{`return string.rep("x", 2^31)`, "toAgent"},
+		{`return ("x"):rep(2^31)`, "toAgent"},
+	}
+	for _, test := range tests {
+		node.Script = test.script
+		if next, err := node.Execute(ivrChannel); err != nil || next != test.next {
+			t.Fatalf("Script %s=%s,err=%v,expect %s", test.script, next, err, test.next)
+		}
+	}
+
+	node.Script = `while true do end`
+	node.Timeout = 100
+	start := time.Now()
+	if next, _ := node.Execute(ivrChannel); next != "toAgent" {
+		t.Fatalf("Endless script=%s,expect OnError toAgent", next)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Endless script stopped after %s,expect 100ms", elapsed)
+	}
+
+	t.Log("Test pass.")
+}
+
+// fakeSwitch answers +OK to the commands of ivrChannel and passes each of
+// them to onCommand.
+func fakeSwitch(ivrChannel *IVRChannel, onCommand func(cmd string)) func() {
+	return fakeApiSwitch(ivrChannel, nil, onCommand)
+}
+
+// fakeApiSwitch answers the api commands with api,the other commands with +OK.
+func fakeApiSwitch(ivrChannel *IVRChannel, api func(cmd string) string, onCommand func(cmd string)) func() {
+
+	client, server := net.Pipe()
+	ivrChannel.Esocket = eventsocket.NewESocket(client, ivrChannel)
+	ivrChannel.Esocket.Init()
+
+	go func() {
+		reader := bufio.NewReader(server)
+		for {
+			lines := make([]string, 0)
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line = strings.TrimSpace(line); line == "" {
+					break
+				}
+				lines = append(lines, line)
+			}
+			if len(lines) == 0 {
+				continue
+			}
+			if api != nil && strings.HasPrefix(lines[0], "api ") {
+				body := api(strings.TrimPrefix(lines[0], "api "))
+				fmt.Fprintf(server, "Content-Type: api/response\nContent-Length: %d\n\n%s", len(body), body)
+				continue
+			}
+			fmt.Fprint(server, "Content-Type: command/reply\nReply-Text: +OK\n\n")
+			onCommand(strings.Join(lines, " | "))
+		}
+	}()
+	return func() { server.Close() }
+}
+
+func TestScriptNodeESL(t *testing.T) {
+
+	ivrChannel := &IVRChannel{Vars: NewCallVars(), Log: calllog.New(), ChannelId: "call-1",
+		Dtmf: make(chan string, Max_DTMF_Length), PlaybackDone: make(chan bool)}
+	masked := make(chan string, 1)
+	closeSwitch := fakeSwitch(ivrChannel, func(cmd string) {
+		switch {
+		case strings.Contains(cmd, "uuid_break call-1"):
+			go func() { ivrChannel.PlaybackDone <- true }()
+		case strings.Contains(cmd, "start_dtmf"):
+			masked <- ivrChannel.maskInput("1234")
+			for _, dtmf := range []string{"1", "2", "3", "4", "#"} {
+				ivrChannel.Dtmf <- dtmf
+			}
+		}
+	})
+	defer closeSwitch()
+
+	// A prompt still playing at the deadline is broken.
+	node := ScriptNode{NodeName: "longPrompt", NextNode: "mainMenu", OnError: "toAgent", Timeout: 200,
+		Script: `esl.play("/tmp/long.wav") return "mainMenu"`}
+	start := time.Now()
+	if next, _ := node.Execute(ivrChannel); next != "toAgent" {
+		t.Fatalf("Script playing at deadline=%s,expect OnError toAgent", next)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Script playing at deadline stopped after %s,expect 200ms", elapsed)
+	}
+	if ivrChannel.cancelPlay != nil {
+		t.Fatal("Playback is still canceled after the script")
+	}
+
+	node = ScriptNode{NodeName: "askPin", OnError: "toAgent", Timeout: 2000,
+		Script: `vars.pin = esl.collect("", 6, 1000, "#", true) return "verifyPin"`}
+	if next, err := node.Execute(ivrChannel); err != nil || next != "verifyPin" || ivrChannel.Vars.Get("pin") != "1234" {
+		t.Fatalf("Collect sensitive=%s,pin=%s,err=%v", next, ivrChannel.Vars.Get("pin"), err)
+	}
+	if logged := <-masked; logged == "1234" {
+		t.Fatal("Sensitive digits are not masked while collecting")
+	}
+	if text := ivrChannel.Esocket.Mask("pin=1234"); strings.Contains(text, "1234") || ivrChannel.maskInput("1234") != "1234" {
+		t.Fatalf("Sensitive digits after collect : %s", text)
+	}
+
+	t.Log("Test pass.")
+}
+
+func TestScriptNodeSlowCollect(t *testing.T) {
+
+	ivrChannel := &IVRChannel{Vars: NewCallVars(), Log: calllog.New(), ChannelId: "call-1",
+		Dtmf: make(chan string, Max_DTMF_Length), PlaybackDone: make(chan bool)}
+	closeSwitch := fakeSwitch(ivrChannel, func(cmd string) {
+		if strings.Contains(cmd, "start_dtmf") {
+			// A slow typist,each digit comes before the timeout of the digit.
+			go func() {
+				for i := 0; i < 10; i++ {
+					time.Sleep(100 * time.Millisecond)
+					select {
+					case ivrChannel.Dtmf <- "1":
+					default:
+					}
+				}
+			}()
+		}
+	})
+	defer closeSwitch()
+
+	node := ScriptNode{NodeName: "askAccount", OnError: "toAgent", Timeout: 300,
+		Script: `vars.account = esl.collect("", 10, 1000) return "verifyAccount"`}
+	start := time.Now()
+	if next, _ := node.Execute(ivrChannel); next != "toAgent" {
+		t.Fatalf("Slow collect=%s,expect OnError toAgent", next)
+	}
+	if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+		t.Fatalf("Slow collect stopped after %s,expect 300ms", elapsed)
+	}
+
+	t.Log("Test pass.")
+}
+
+func TestScriptNodeLoad(t *testing.T) {
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "score.lua"), []byte(`return "vipService"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := ScriptNode{NodeName: "score", File: "score.lua"}.Load(dir)
+	if err != nil {
+		t.Fatalf("Load script failure for %s", err.Error())
+	}
+	if file := loaded.(ScriptNode).File; file != filepath.Join(dir, "score.lua") {
+		t.Fatalf("File=%s,expect relative to the flow file", file)
+	}
+
+	// The script is compiled once,the file is not read by the call.
+	os.Remove(filepath.Join(dir, "score.lua"))
+	ivrChannel := &IVRChannel{Vars: NewCallVars(), Log: calllog.New()}
+	if next, err := loaded.Execute(ivrChannel); err != nil || next != "vipService" {
+		t.Fatalf("Execute loaded script=%s,err=%v,expect vipService", next, err)
+	}
+
+	if _, err := (ScriptNode{NodeName: "bad", Script: `return "unclosed`}).Load(dir); err == nil {
+		t.Fatal("Load script with syntax error,expect error")
+	}
+	if _, err := (ScriptNode{NodeName: "missing", File: "missing.lua"}).Load(dir); err == nil {
+		t.Fatal("Load missing script file,expect error")
+	}
+
+	t.Log("Test pass.")
+}
@@ -0,0 +1,221 @@
+// fs/ivr/ ServerConfig
+
+package ivr
+
+import (
+	l4g "code.google.com/p/log4go"
+	"encoding/xml"
+	"fs/ivr/calllog"
+	"io/ioutil"
+	"strings"
+)
+
+const Server_Config_File string = "server.xml"
+
+const Overload_Action_Busy string = "busy"
+const Overload_Action_Reject string = "reject"
+
+const Default_Flow_Name string = "default"
+const Default_Root_Node string = "root"
+const Default_Shutdown_Timeout int = 60000
+
+type ServerConfig struct {
+	FlowFile        string
+	AdminPort       int
+	ShutdownTimeout int // Millisecond,wait for the calls in progress on SIGINT/SIGTERM.
+	Limits          Limits
+	Flows           Flows
+	LogLevels       LogLevels
+	Persistence     Persistence
+	QueryDB         QueryDBConfig
+	TTS             TTSConfig
+	ASR             ASRConfig
+	Say             SayConfig
+	Languages       Languages
+}
+
+// Limits protect the server and its backends from too many calls.
+// Zero values mean no limit.
+type Limits struct {
+	MaxCalls       int
+	OverloadAction string // busy or reject
+	BusyPrompt     string
+	AniMaxCalls    int // Max calls per ANI in AniPeriod.
+	AniPeriod      int // Millisecond.
+}
+
+type Flows struct {
+	Flow []Flow
+}
+
+// Flow is an entry point of the call flow selected by DNIS.
+type Flow struct {
+	Name     string `xml:"name,attr"`
+	DNIS     string `xml:"dnis,attr"` // Prefix match,empty matches all.
+	Root     string `xml:"root,attr"`
+	MaxCalls int    `xml:"maxCalls,attr"`
+}
+
+const Persist_Type_MySQL string = "mysql"
+const Persist_Type_SQLite string = "sqlite"
+const Persist_Type_Postgres string = "postgres"
+const Persist_Type_JSONL string = "jsonl"
+const Persist_Type_None string = "none"
+
+// Persistence selects the store of call records and tunes the asynchronous
+// writer in front of it.
+type Persistence struct {
+	Type          string // mysql,sqlite,postgres,jsonl or none.
+	DBAddr        string
+	DBUser        string
+	DBPwd         string
+	DBName        string // Database file of sqlite.
+	File          string // Record file of jsonl.
+	MaxSize       int    // MB,rotate the record file when reached.
+	Daily         bool   // Rotate the record file every day.
+	QueueSize     int
+	BatchSize     int
+	FlushInterval int // Millisecond.
+	MaxRetries    int
+	RetryBackoff  int // Millisecond,doubled on each retry.
+	SpillFile     string
+	RejectFile    string // Records the database refuses for good,eg. a duplicate CallId.
+}
+
+func (config Persistence) withDefaults() Persistence {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 50
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 1000
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 200
+	}
+	if config.Type == "" {
+		config.Type = Persist_Type_None
+	}
+	if config.File == "" {
+		config.File = "ivr_cdr.jsonl"
+	}
+	if config.SpillFile == "" {
+		config.SpillFile = "ivr_cdr.spill"
+	}
+	if config.RejectFile == "" {
+		config.RejectFile = "ivr_cdr.rejected"
+	}
+	return config
+}
+
+// TTSConfig is the default speech engine of TTS prompts,flite comes with
+// FreeSWITCH (mod_flite) and needs no license.
+type TTSConfig struct {
+	Engine string
+	Voice  string
+}
+
+// ASRConfig is the default speech recognition engine of SpeechCollectNode,
+// eg. unimrcp (mod_unimrcp) or pocketsphinx.
+type ASRConfig struct {
+	Engine string
+}
+
+// SayConfig selects how Say prompts are read.Without the say modules of
+// FreeSWITCH set Fallback,the value is then read digit by digit from
+// <DigitsPath>/<digit>.wav of the sound library.
+type SayConfig struct {
+	Fallback   bool
+	DigitsPath string
+}
+
+type LogLevels struct {
+	Override []LogOverride
+}
+
+// LogOverride sets the log level of the calls from an ANI or with a call uuid.
+type LogOverride struct {
+	Key   string `xml:"key,attr"`
+	Level string `xml:"level,attr"`
+}
+
+var serverConfig *ServerConfig = NewServerConfig()
+
+func NewServerConfig() *ServerConfig {
+	config := new(ServerConfig)
+	config.FlowFile = Ivr_Config_File
+	config.ShutdownTimeout = Default_Shutdown_Timeout
+	config.Limits.OverloadAction = Overload_Action_Reject
+	config.TTS.Engine = Default_TTS_Engine
+	config.TTS.Voice = Default_TTS_Voice
+	config.ASR.Engine = Default_ASR_Engine
+	config.Say.DigitsPath = Default_Digits_Path
+	return config
+}
+
+func LoadServerConfig(name string) error {
+
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		l4g.Error("Load server config file[%s] failure for %s", name, err.Error())
+		return err
+	}
+
+	config := NewServerConfig()
+	err = xml.Unmarshal(content, config)
+	if err != nil {
+		l4g.Error("Unmarshal server config xml failure for %s", err.Error())
+		return err
+	}
+
+	if config.Limits.OverloadAction != Overload_Action_Busy {
+		config.Limits.OverloadAction = Overload_Action_Reject
+	}
+
+	for i := range config.Flows.Flow {
+		if config.Flows.Flow[i].Root == "" {
+			config.Flows.Flow[i].Root = Default_Root_Node
+		}
+	}
+
+	for _, override := range config.LogLevels.Override {
+		if level, ok := calllog.ParseLevel(override.Level); ok {
+			calllog.SetOverride(override.Key, level)
+		} else {
+			l4g.Warn("Unknown log level %s for %s", override.Level, override.Key)
+		}
+	}
+
+	serverConfig = config
+	l4g.Trace("Load server config flowFile=%s,flows=%d,maxCalls=%d", config.FlowFile, len(config.Flows.Flow), config.Limits.MaxCalls)
+	return nil
+}
+
+// FindFlow returns a copy of the flow with the longest DNIS prefix matching
+// dnis,the config is shared by the calls.
+func (config *ServerConfig) FindFlow(dnis string) *Flow {
+
+	var found *Flow = nil
+	for i := range config.Flows.Flow {
+		flow := &config.Flows.Flow[i]
+		if strings.HasPrefix(dnis, flow.DNIS) {
+			if found == nil || len(flow.DNIS) > len(found.DNIS) {
+				found = flow
+			}
+		}
+	}
+
+	if found == nil {
+		return &Flow{Name: Default_Flow_Name, Root: Default_Root_Node}
+	}
+	flow := *found
+	if flow.Root == "" {
+		flow.Root = Default_Root_Node
+	}
+	return &flow
+}
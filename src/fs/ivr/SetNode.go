@@ -0,0 +1,104 @@
+// fs/ivr/ SetNode
+
+package ivr
+
+import (
+	"errors"
+)
+
+const Set_Channel_Set string = "set"
+const Set_Channel_Export string = "export"
+
+// Assignment sets call variable Var from an expression (Expr),another variable
+// (From,eg. DtmfValue) or a literal which may hold ${name} (Value),in this order.
+// Channel copies the value to the FreeSWITCH channel : set,or export so the
+// bridged channel gets it too.
+type Assignment struct {
+	Var     string `xml:"var,attr"`
+	Value   string `xml:"value,attr"`
+	Expr    string `xml:"expr,attr"`
+	From    string `xml:"from,attr"`
+	Channel string `xml:"channel,attr"`
+
+	expression *Expression // Expr compiled when the flow file is loaded.
+}
+
+type Assignments struct {
+	Set []Assignment
+}
+
+// SetNode runs its assignments in order,a later one sees the earlier ones.
+type SetNode struct {
+	NodeName    string `xml:"name,attr"`
+	Assignments Assignments
+	NextNode    string
+}
+
+// Load compiles the expressions,a syntax error fails the flow file.
+func (node SetNode) Load(dir string) (IVRNode, error) {
+	assignments := make([]Assignment, len(node.Assignments.Set))
+	for i, assignment := range node.Assignments.Set {
+		if assignment.Expr != "" {
+			expression, err := CompileExpression(assignment.Expr)
+			if err != nil {
+				return nil, errors.New("Invalid expression of " + assignment.Var + " [" + assignment.Expr + "] : " + err.Error())
+			}
+			assignment.expression = expression
+		}
+		assignments[i] = assignment
+	}
+	node.Assignments.Set = assignments
+	return node, nil
+}
+
+func (assignment Assignment) value(vars *CallVars) (string, error) {
+	if assignment.expression != nil {
+		value, err := assignment.expression.Eval(vars)
+		if err != nil {
+			return "", err
+		}
+		return exprString(value), nil
+	}
+	if assignment.Expr != "" {
+		value, err := EvalExpression(assignment.Expr, vars)
+		if err != nil {
+			return "", err
+		}
+		return exprString(value), nil
+	}
+	if assignment.From != "" {
+		return vars.Get(assignment.From), nil
+	}
+	return vars.Expand(assignment.Value), nil
+}
+
+func (node SetNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	for _, assignment := range node.Assignments.Set {
+		value, err := assignment.value(ivrChannel.Vars)
+		if err != nil {
+			ivrChannel.Log.Warn("Set %s failure for %s", assignment.Var, err.Error())
+			continue
+		}
+		ivrChannel.Vars.Set(assignment.Var, value)
+		ivrChannel.Log.Debug("Set call variable %s=%s", assignment.Var, ivrChannel.Esocket.Mask(value))
+
+		switch assignment.Channel {
+		case Set_Channel_Set:
+			err = ivrChannel.Esocket.SetVar(assignment.Var, value)
+		case Set_Channel_Export:
+			err = ivrChannel.Esocket.Export(assignment.Var, value)
+		}
+		if err != nil {
+			ivrChannel.Log.Warn("%s channel variable %s failure for %s", assignment.Channel, assignment.Var, err.Error())
+		}
+	}
+
+	return node.NextNode, nil
+}
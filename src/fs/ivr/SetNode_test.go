@@ -0,0 +1,59 @@
+// SetNode test
+
+package ivr
+
+import (
+	"testing"
+)
+
+func TestAssignmentValue(t *testing.T) {
+
+	vars := NewCallVars()
+	vars.Set("DtmfValue", "6225")
+	vars.Set("balance", "100")
+
+	cases := []struct {
+		assignment Assignment
+		expect     string
+	}{
+		{Assignment{Var: "account", From: "DtmfValue"}, "6225"},
+		{Assignment{Var: "fee", Expr: "balance * 0.015"}, "1.5"},
+		{Assignment{Var: "vip", Expr: "balance >= 100"}, "true"},
+		{Assignment{Var: "greeting", Value: "Account ${DtmfValue}"}, "Account 6225"},
+	}
+	for _, c := range cases {
+		value, err := c.assignment.value(vars)
+		if err != nil || value != c.expect {
+			t.Fatalf("Set %s=%s,err=%v,expect %s", c.assignment.Var, value, err, c.expect)
+		}
+	}
+	t.Log("Test pass.")
+}
+
+func TestSetNodeLoad(t *testing.T) {
+
+	node := SetNode{NodeName: "setFee", Assignments: Assignments{Set: []Assignment{
+		{Var: "fee", Expr: "balance * 0.015"},
+		{Var: "greeting", Value: "Account ${DtmfValue}"},
+	}}}
+	loaded, err := node.Load("")
+	if err != nil {
+		t.Fatalf("Load set node failure for %s", err.Error())
+	}
+	assignments := loaded.(SetNode).Assignments.Set
+	if assignments[0].expression == nil || assignments[1].expression != nil {
+		t.Fatal("Expr is not compiled when loaded")
+	}
+
+	vars := NewCallVars()
+	vars.Set("balance", "100")
+	if value, err := assignments[0].value(vars); err != nil || value != "1.5" {
+		t.Fatalf("Loaded fee=%s,err=%v,expect 1.5", value, err)
+	}
+
+	node.Assignments.Set[0].Expr = "balance * "
+	if _, err := node.Load(""); err == nil {
+		t.Fatal("Load invalid expression,expect error")
+	}
+	t.Log("Test pass.")
+}
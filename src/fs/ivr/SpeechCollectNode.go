@@ -0,0 +1,354 @@
+// fs/ivr/ SpeechCollectNode
+
+package ivr
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fs/ivr/eventsocket"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const Default_ASR_Engine string = "unimrcp"
+const Default_Speech_Timeout int = 5000
+
+const Speech_Type_Detected string = "detected-speech"
+const Speech_Type_Begin string = "begin-speaking"
+
+const Input_Mode_Speech string = "speech"
+const Input_Mode_DTMF string = "dtmf"
+
+var noSpeechErr error = errors.New("No speech")
+
+// speechResult is the NLSML result of DETECTED_SPEECH,eg. of unimrcp
+//
+//	<result><interpretation grammar="yesno" confidence="0.87">
+//		<instance>yes</instance><input mode="speech">yes</input>
+//	</interpretation></result>
+//
+// Some engines give score and <result> instead of confidence and <instance>,
+// or a bare <interpretation>.
+type speechResult struct {
+	XMLName        xml.Name
+	Interpretation []speechInterpretation `xml:"interpretation"`
+}
+
+type speechInterpretation struct {
+	Confidence string `xml:"confidence,attr"`
+	Score      string `xml:"score,attr"`
+	Instance   struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"instance"`
+	Result string `xml:"result"`
+	Input  struct {
+		Text    string    `xml:",chardata"`
+		NoMatch *struct{} `xml:"nomatch"`
+		NoInput *struct{} `xml:"noinput"`
+	} `xml:"input"`
+}
+
+var speechTagRex = regexp.MustCompile(`<[^>]*>`)
+
+// parseSpeechResult returns the text,the interpretation and the confidence
+// (0-100) of the best interpretation of body.
+func parseSpeechResult(body string) (string, string, int, error) {
+
+	var result speechResult
+	if err := xml.Unmarshal([]byte(strings.TrimSpace(body)), &result); err != nil {
+		return "", "", 0, err
+	}
+	if result.XMLName.Local == "interpretation" {
+		var interpretation speechInterpretation
+		if err := xml.Unmarshal([]byte(strings.TrimSpace(body)), &interpretation); err != nil {
+			return "", "", 0, err
+		}
+		result.Interpretation = []speechInterpretation{interpretation}
+	}
+	if len(result.Interpretation) == 0 {
+		return "", "", 0, noSpeechErr
+	}
+
+	best := result.Interpretation[0]
+	if best.Input.NoInput != nil || best.Input.NoMatch != nil {
+		return "", "", 0, noSpeechErr
+	}
+
+	text := strings.TrimSpace(best.Input.Text)
+	interpretation := strings.TrimSpace(speechTagRex.ReplaceAllString(best.Instance.Inner, " "))
+	interpretation = strings.Join(strings.Fields(interpretation), " ")
+	if interpretation == "" {
+		interpretation = strings.TrimSpace(best.Result)
+	}
+	if interpretation == "" {
+		interpretation = text
+	}
+	if text == "" {
+		text = interpretation
+	}
+	if text == "" {
+		return "", "", 0, noSpeechErr
+	}
+
+	confidence := 100
+	score := best.Confidence
+	if score == "" {
+		score = best.Score
+	}
+	if score != "" {
+		value, err := strconv.ParseFloat(score, 64)
+		if err != nil {
+			return "", "", 0, err
+		}
+		// MRCPv2 gives 0.0-1.0,MRCPv1 and pocketsphinx 0-100.
+		if value <= 1 {
+			value = value * 100
+		}
+		confidence = int(value + 0.5)
+	}
+	return text, interpretation, confidence, nil
+}
+
+// wordsGrammar writes the SRGS grammar of a comma separated word list to the
+// temporary directory and returns its file.
+func wordsGrammar(words string) (string, error) {
+
+	items := make([]string, 0)
+	for _, word := range strings.Split(words, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			var escaped strings.Builder
+			xml.EscapeText(&escaped, []byte(word))
+			items = append(items, "\t\t\t<item>"+escaped.String()+"</item>")
+		}
+	}
+	if len(items) == 0 {
+		return "", errors.New("Empty word list")
+	}
+
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<grammar xmlns="http://www.w3.org/2001/06/grammar" version="1.0" mode="voice" root="words">
+	<rule id="words" scope="public">
+		<one-of>
+` + strings.Join(items, "\n") + `
+		</one-of>
+	</rule>
+</grammar>
+`
+	sum := sha1.Sum([]byte(content))
+	file := filepath.Join(os.TempDir(), "ivr-words-"+hex.EncodeToString(sum[:8])+".grxml")
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+	return file, ioutil.WriteFile(file, []byte(content), 0644)
+}
+
+// SpeechCollectNode collects speech recognized by detect_speech with Grammar
+// (SRGS/GRXML file or URI,eg. builtin:grammar/boolean) or Words,eg. yes,no,agent.
+// With DtmfGrammar the caller may type the input too.The input is kept in call
+// variables speech_text,speech_interpretation,speech_confidence (0-100) and
+// speech_input_mode (speech or dtmf).Speech below MinConfidence goes to
+// NoMatch,below ConfirmConfidence to Confirm,eg. a menu asking "did you say
+// ${speech_interpretation}?".DTMF input needs no confirmation.
+type SpeechCollectNode struct {
+	NodeName          string `xml:"name,attr"`
+	Prompts           PromptEntity
+	Engine            string // ASR engine,default of the server config.
+	Grammar           string
+	Words             string
+	DtmfGrammar       string // Grammar of DTMF input.
+	Timeout           int    // Millisecond after the prompts.
+	MinConfidence     int
+	ConfirmConfidence int
+	NextNode          string
+	Confirm           string
+	NoInput           string
+	NoMatch           string
+}
+
+func (node SpeechCollectNode) detectArg() (string, error) {
+	engine := node.Engine
+	if engine == "" {
+		engine = serverConfig.ASR.Engine
+	}
+	grammar := node.Grammar
+	if grammar == "" {
+		file, err := wordsGrammar(node.Words)
+		if err != nil {
+			return "", err
+		}
+		grammar = file
+	}
+	return engine + " " + grammar + " " + node.NodeName, nil
+}
+
+// speechBranch returns the next node of speech with confidence.
+func (node SpeechCollectNode) speechBranch(confidence int) (string, string) {
+	if confidence < node.MinConfidence {
+		return node.NoMatch, Input_NoMatch
+	}
+	if confidence < node.ConfirmConfidence && node.Confirm != "" {
+		return node.Confirm, Input_Match
+	}
+	return node.NextNode, Input_Match
+}
+
+func (channel *IVRChannel) onDetectedSpeech(event *eventsocket.Event) {
+	switch event.Header["Speech-Type"] {
+	case Speech_Type_Begin:
+		channel.Log.Trace("Caller begins speaking")
+	case Speech_Type_Detected:
+		select {
+		case channel.Speech <- event.Body:
+		default:
+			channel.Log.Warn("Drop detected speech,no node is waiting")
+		}
+	}
+}
+
+func (node SpeechCollectNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	arg, err := node.detectArg()
+	if err != nil {
+		return "", err
+	}
+
+	// Clear dtmf and speech of the former nodes.
+	for len(ivrChannel.Dtmf) > 0 {
+		<-ivrChannel.Dtmf
+	}
+	for len(ivrChannel.Speech) > 0 {
+		<-ivrChannel.Speech
+	}
+
+	var dtmfInput chan string = nil
+	grammar, hybrid := ivrGrammarMap[node.DtmfGrammar]
+	if hybrid {
+		// Digits typed while the prompts are playing are sensitive too.
+		ivrChannel.setSensitiveInput(grammar.Sensitive)
+		defer ivrChannel.setSensitiveInput(false)
+		dtmfInput = ivrChannel.Dtmf
+		ivrChannel.Esocket.StartDTMF()
+		defer ivrChannel.Esocket.StopDTMF()
+	} else if node.DtmfGrammar != "" {
+		ivrChannel.Log.Warn("Grammar not find for %s at node %s", node.DtmfGrammar, node.NodeName)
+	}
+
+	// Speech during the prompts is recognized too.
+	if _, err = ivrChannel.Esocket.Execute("detect_speech", arg); err != nil {
+		return "", err
+	}
+	defer ivrChannel.Esocket.Execute("detect_speech", "stop")
+
+	executePrompt(node.Prompts.Prompt, ivrChannel)
+
+	return node.waitInput(ivrChannel, grammar, dtmfInput)
+}
+
+// waitInput waits Timeout for speech or the first digit of dtmfInput,once
+// the caller types the digits have the Timeout of grammar and speech is
+// ignored.
+func (node SpeechCollectNode) waitInput(ivrChannel *IVRChannel, grammar Grammar, dtmfInput chan string) (string, error) {
+
+	timeoutMs := node.Timeout
+	if timeoutMs <= 0 {
+		timeoutMs = Default_Speech_Timeout
+	}
+	timeout := eventsocket.CheckTimeout(timeoutMs)
+
+	dtmfValue := ""
+	for {
+		select {
+		case <-timeout:
+			if dtmfValue != "" {
+				return node.dtmfInput(ivrChannel, grammar, dtmfValue)
+			}
+			ivrChannel.Log.Warn("Timeout,no speech.")
+			ivrChannel.NoInputTimes = ivrChannel.NoInputTimes + 1
+			ivrChannel.recordInput("", false, Input_NoInput)
+			return node.NoInput, nil
+		case body := <-ivrChannel.Speech:
+			if dtmfValue != "" {
+				ivrChannel.Log.Trace("Ignore speech while typing")
+				continue
+			}
+			return node.speechInput(ivrChannel, body)
+		case dtmf := <-dtmfInput:
+			if dtmf == grammar.Terminator {
+				return node.dtmfInput(ivrChannel, grammar, dtmfValue)
+			}
+			dtmfValue = dtmfValue + dtmf
+			if len(dtmfValue) >= grammar.MaxLen {
+				return node.dtmfInput(ivrChannel, grammar, dtmfValue)
+			}
+			if grammar.Timeout > 0 {
+				timeout = eventsocket.CheckTimeout(grammar.Timeout)
+			}
+		case <-ivrChannel.ChannelHangup:
+			ivrChannel.Log.Trace("Channel hangup.")
+			return "", errors.New("Channel hangup.")
+		}
+	}
+}
+
+func (node SpeechCollectNode) speechInput(ivrChannel *IVRChannel, body string) (string, error) {
+
+	text, interpretation, confidence, err := parseSpeechResult(body)
+	if err != nil {
+		if err != noSpeechErr {
+			ivrChannel.Log.Warn("Speech result is invalid for %s", err.Error())
+		}
+		ivrChannel.NoMatchTimes = ivrChannel.NoMatchTimes + 1
+		ivrChannel.recordInput("", false, Input_NoMatch)
+		return node.NoMatch, nil
+	}
+
+	ivrChannel.Vars.Set("speech_text", text)
+	ivrChannel.Vars.Set("speech_interpretation", interpretation)
+	ivrChannel.Vars.Set("speech_confidence", strconv.Itoa(confidence))
+	ivrChannel.Vars.Set("speech_input_mode", Input_Mode_Speech)
+
+	nextNode, result := node.speechBranch(confidence)
+	if result == Input_NoMatch {
+		ivrChannel.NoMatchTimes = ivrChannel.NoMatchTimes + 1
+	}
+	ivrChannel.recordInput(interpretation, false, result)
+	ivrChannel.Log.Trace("Collect speech text=%s,interpretation=%s,confidence=%d,nextNode=%s", text, interpretation, confidence, nextNode)
+	return nextNode, nil
+}
+
+func (node SpeechCollectNode) dtmfInput(ivrChannel *IVRChannel, grammar Grammar, dtmfValue string) (string, error) {
+
+	if grammar.Sensitive {
+		ivrChannel.Esocket.AddSecret(dtmfValue)
+	}
+	dtmfRex, err := regexp.Compile(ivrChannel.Vars.ExpandRegexp(grammar.Express))
+	if err != nil {
+		ivrChannel.Log.Warn("Grammar %s express is invalid for %s", grammar.GName, err.Error())
+	}
+	if err != nil || !dtmfRex.MatchString(dtmfValue) {
+		ivrChannel.NoMatchTimes = ivrChannel.NoMatchTimes + 1
+		ivrChannel.recordInput(dtmfValue, grammar.Sensitive, Input_NoMatch)
+		return node.NoMatch, nil
+	}
+
+	ivrChannel.setDtmfValue(dtmfValue, grammar.Sensitive)
+	ivrChannel.Vars.Set("speech_text", dtmfValue)
+	ivrChannel.Vars.Set("speech_interpretation", dtmfValue)
+	ivrChannel.Vars.Set("speech_confidence", "100")
+	ivrChannel.Vars.Set("speech_input_mode", Input_Mode_DTMF)
+	ivrChannel.recordInput(dtmfValue, grammar.Sensitive, Input_Match)
+	ivrChannel.Log.Trace("Collect dtmfValue=%s,nextNode=%s", ivrChannel.MaskedDtmfValue(), node.NextNode)
+	return node.NextNode, nil
+}
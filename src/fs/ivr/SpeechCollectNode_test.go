@@ -0,0 +1,164 @@
+// SpeechCollectNode test
+
+package ivr
+
+import (
+	"fs/ivr/calllog"
+	"fs/ivr/eventsocket"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSpeechResult(t *testing.T) {
+
+	tests := []struct {
+		body           string
+		text           string
+		interpretation string
+		confidence     int
+		err            bool
+	}{
+		{`<?xml version="1.0"?>
+<result><interpretation grammar="yesno" confidence="0.87">
+	<instance>yes</instance><input mode="speech">yeah</input>
+</interpretation></result>`, "yeah", "yes", 87, false},
+		{`<result><interpretation grammar="balance" confidence="62"><instance><action>balance</action><account>saving</account></instance><input mode="speech">saving balance</input></interpretation></result>`,
+			"saving balance", "balance saving", 62, false},
+		{`<interpretation grammar="agent" score="95"><result name="match">agent</result><input>agent</input></interpretation>`, "agent", "agent", 95, false},
+		{`<result><interpretation grammar="agent" score="95"><result name="match">agent</result><input>agent please</input></interpretation></result>`, "agent please", "agent", 95, false},
+		{`<result><interpretation><input mode="speech">transfer</input></interpretation></result>`, "transfer", "transfer", 100, false},
+		{`<result><interpretation><input><nomatch/></input></interpretation></result>`, "", "", 0, true},
+		{`<result><interpretation><input><noinput/></input></interpretation></result>`, "", "", 0, true},
+		{`<result></result>`, "", "", 0, true},
+		{`not xml`, "", "", 0, true},
+	}
+	for _, test := range tests {
+		text, interpretation, confidence, err := parseSpeechResult(test.body)
+		if (err != nil) != test.err || text != test.text || interpretation != test.interpretation || confidence != test.confidence {
+			t.Fatalf("parseSpeechResult(%s)=%s,%s,%d,%v,expect %s,%s,%d", test.body, text, interpretation, confidence, err, test.text, test.interpretation, test.confidence)
+		}
+	}
+
+	t.Log("Test pass.")
+}
+
+func TestSpeechCollectNode(t *testing.T) {
+
+	node := SpeechCollectNode{NodeName: "askService", Words: "balance, agent ,R&D", MinConfidence: 40, ConfirmConfidence: 70,
+		NextNode: "routeService", Confirm: "confirmService", NoMatch: "NoMatch"}
+
+	tests := []struct {
+		confidence int
+		next       string
+		result     string
+	}{
+		{39, "NoMatch", Input_NoMatch},
+		{40, "confirmService", Input_Match},
+		{69, "confirmService", Input_Match},
+		{70, "routeService", Input_Match},
+	}
+	for _, test := range tests {
+		if next, result := node.speechBranch(test.confidence); next != test.next || result != test.result {
+			t.Fatalf("speechBranch(%d)=%s,%s,expect %s,%s", test.confidence, next, result, test.next, test.result)
+		}
+	}
+	node.Confirm = ""
+	if next, _ := node.speechBranch(50); next != "routeService" {
+		t.Fatalf("speechBranch without Confirm=%s,expect routeService", next)
+	}
+
+	arg, err := node.detectArg()
+	if err != nil || !strings.HasPrefix(arg, Default_ASR_Engine+" ") || !strings.HasSuffix(arg, " askService") {
+		t.Fatalf("detectArg=%s,err=%v", arg, err)
+	}
+	file := strings.Fields(arg)[1]
+	content, err := ioutil.ReadFile(file)
+	if err != nil || !strings.Contains(string(content), "<item>agent</item>") || !strings.Contains(string(content), "<item>R&amp;D</item>") {
+		t.Fatalf("Words grammar %s=%s,err=%v", file, content, err)
+	}
+	if again, _ := node.detectArg(); again != arg {
+		t.Fatalf("detectArg again=%s,expect %s", again, arg)
+	}
+
+	node.Words = " , "
+	if _, err := node.detectArg(); err == nil {
+		t.Fatal("Empty words,expect error")
+	}
+	node.Grammar = "builtin:grammar/boolean"
+	node.Engine = "pocketsphinx"
+	if arg, _ := node.detectArg(); arg != "pocketsphinx builtin:grammar/boolean askService" {
+		t.Fatalf("detectArg=%s", arg)
+	}
+
+	ivrChannel := &IVRChannel{Log: calllog.New(), Speech: make(chan string, 1)}
+	event := &eventsocket.Event{Header: eventsocket.EventHeader{"Speech-Type": Speech_Type_Begin}}
+	ivrChannel.onDetectedSpeech(event)
+	if len(ivrChannel.Speech) != 0 {
+		t.Fatal("Begin speaking,expect no result")
+	}
+	event = &eventsocket.Event{Header: eventsocket.EventHeader{"Speech-Type": Speech_Type_Detected}, Body: "<result/>"}
+	ivrChannel.onDetectedSpeech(event)
+	ivrChannel.onDetectedSpeech(event)
+	if len(ivrChannel.Speech) != 1 || <-ivrChannel.Speech != "<result/>" {
+		t.Fatal("Detected speech,expect one result")
+	}
+
+	t.Log("Test pass.")
+}
+
+func TestSpeechCollectNodeDtmf(t *testing.T) {
+
+	node := SpeechCollectNode{NodeName: "askPin", Timeout: 2000, NextNode: "verifyPin", NoInput: "NoInput", NoMatch: "NoMatch"}
+	grammar := Grammar{GName: "g_pin", MaxLen: 4, Terminator: "#", Timeout: 200, Express: "^[0-9]+$", Sensitive: true}
+
+	newChannel := func() *IVRChannel {
+		return &IVRChannel{Vars: NewCallVars(), Log: calllog.New(), Esocket: &eventsocket.ESocket{},
+			Record: NewCallRecord(time.Now()), Dtmf: make(chan string, Max_DTMF_Length), Speech: make(chan string, 1)}
+	}
+
+	ivrChannel := newChannel()
+	for _, dtmf := range []string{"1", "2", "3", "4"} {
+		ivrChannel.Dtmf <- dtmf
+	}
+	next, err := node.waitInput(ivrChannel, grammar, ivrChannel.Dtmf)
+	if err != nil || next != "verifyPin" || !ivrChannel.DtmfSensitive {
+		t.Fatalf("Sensitive dtmf next=%s,err=%v,sensitive=%v", next, err, ivrChannel.DtmfSensitive)
+	}
+	if masked := ivrChannel.Esocket.Mask("pin=1234"); strings.Contains(masked, "1234") {
+		t.Fatalf("Sensitive dtmf not masked : %s", masked)
+	}
+	if input := ivrChannel.Record.Inputs[0]; input.Value == "1234" || input.Result != Input_Match {
+		t.Fatalf("Sensitive dtmf recorded %s,%s", input.Value, input.Result)
+	}
+
+	// Speech while typing must not put off the inter digit timeout.
+	ivrChannel = newChannel()
+	ivrChannel.Dtmf <- "7"
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(50 * time.Millisecond):
+				select {
+				case ivrChannel.Speech <- "<result/>":
+				default:
+				}
+			}
+		}
+	}()
+	start := time.Now()
+	next, err = node.waitInput(ivrChannel, grammar, ivrChannel.Dtmf)
+	if err != nil || next != "verifyPin" || ivrChannel.DtmfValue != "7" {
+		t.Fatalf("Dtmf with speech next=%s,err=%v,dtmf=%s", next, err, ivrChannel.DtmfValue)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Dtmf with speech took %v,expect the inter digit timeout", elapsed)
+	}
+
+	t.Log("Test pass.")
+}
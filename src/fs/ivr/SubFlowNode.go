@@ -0,0 +1,135 @@
+// fs/ivr/ SubFlowNode
+
+package ivr
+
+import (
+	"errors"
+	"strings"
+)
+
+const Max_SubFlow_Depth int = 8
+
+var noCallerErr error = errors.New("Return without caller")
+
+// SubFlow is a flow called by SubFlowNode,its nodes are in the flow file or
+// in File,which is loaded with the flow file.
+type SubFlow struct {
+	Name string `xml:"name,attr"`
+	Root string `xml:"root,attr"`
+	File string `xml:"file,attr"` // Relative to the flow file.
+}
+
+type SubFlows struct {
+	SubFlow []SubFlow
+}
+
+var ivrSubFlowMap map[string]SubFlow = make(map[string]SubFlow)
+var ivrFlowFiles []string
+
+// VarMapping sets call variable Var to Value,${name} in Value is replaced with
+// the variable.
+type VarMapping struct {
+	Var   string `xml:"var,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type VarMappings struct {
+	Map []VarMapping
+}
+
+type SubFlowResult struct {
+	Value    string `xml:"value,attr"`
+	NextNode string `xml:"nextNode,attr"`
+}
+
+type SubFlowResults struct {
+	Result []SubFlowResult
+}
+
+// CallFrame is a sub flow call waiting for its ReturnNode.
+type CallFrame struct {
+	Node    string // The SubFlowNode.
+	Flow    string
+	Outputs []VarMapping
+	Results []SubFlowResult
+	Next    string
+}
+
+// SubFlowNode calls flow Flow as a subroutine.Inputs are set before the root
+// node of the flow runs and Outputs when its ReturnNode is reached,the flows
+// share the call variables so the mappings rename them between the flows,
+// eg. <Map var="authAni" value="${ANI}"/>.The call goes on with the Result of
+// the return value,else NextNode.
+type SubFlowNode struct {
+	NodeName string `xml:"name,attr"`
+	Flow     string
+	Inputs   VarMappings
+	Outputs  VarMappings
+	Results  SubFlowResults
+	NextNode string
+}
+
+func (node SubFlowNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	subFlow, ok := ivrSubFlowMap[node.Flow]
+	if !ok {
+		return "", errors.New("Sub flow not find : " + node.Flow)
+	}
+	if len(ivrChannel.CallStack) >= Max_SubFlow_Depth {
+		return "", errors.New("Sub flow call stack overflow : " + node.Flow)
+	}
+
+	for _, input := range node.Inputs.Map {
+		ivrChannel.Vars.Set(input.Var, ivrChannel.Vars.Expand(input.Value))
+	}
+
+	ivrChannel.CallStack = append(ivrChannel.CallStack, CallFrame{Node: node.NodeName, Flow: subFlow.Name,
+		Outputs: node.Outputs.Map, Results: node.Results.Result, Next: node.NextNode})
+	ivrChannel.Log.Info("Call sub flow %s depth=%d", subFlow.Name, len(ivrChannel.CallStack))
+
+	return subFlow.Root, nil
+}
+
+// ReturnNode ends the sub flow and resumes its caller,Value is the return
+// value of the flow and is kept in call variable subflow_result.
+type ReturnNode struct {
+	NodeName string `xml:"name,attr"`
+	Value    string
+}
+
+func (node ReturnNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	depth := len(ivrChannel.CallStack)
+	if depth == 0 {
+		return "", noCallerErr
+	}
+	frame := ivrChannel.CallStack[depth-1]
+	ivrChannel.CallStack = ivrChannel.CallStack[:depth-1]
+
+	for _, output := range frame.Outputs {
+		ivrChannel.Vars.Set(output.Var, ivrChannel.Vars.Expand(output.Value))
+	}
+
+	value := strings.TrimSpace(ivrChannel.Vars.Expand(node.Value))
+	ivrChannel.Vars.Set("subflow_result", value)
+	ivrChannel.Log.Info("Return from sub flow %s to %s,result=%s", frame.Flow, frame.Node, value)
+
+	for _, result := range frame.Results {
+		if result.Value == value {
+			return result.NextNode, nil
+		}
+	}
+	return frame.Next, nil
+}
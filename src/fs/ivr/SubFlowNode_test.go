@@ -0,0 +1,115 @@
+// SubFlowNode test
+
+package ivr
+
+import (
+	"fs/ivr/calllog"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testMainFlow = `<IVRConfig>
+	<SubFlows>
+		<SubFlow name="authenticate" root="authStart" file="flows/auth.xml"/>
+	</SubFlows>
+	<Nodes>
+		<RootNode name="root"><NextNode>checkCaller</NextNode></RootNode>
+		<SubFlowNode name="checkCaller">
+			<Flow>authenticate</Flow>
+			<Inputs><Map var="authAni" value="${ANI}"/></Inputs>
+			<Outputs><Map var="customer" value="vip-${authAni}"/></Outputs>
+			<Results><Result value="failed" nextNode="toAgent"/></Results>
+			<NextNode>mainMenu</NextNode>
+		</SubFlowNode>
+	</Nodes>
+</IVRConfig>`
+
+const testAuthFlow = `<IVRConfig>
+	<Vars><Var name="authTries" value="3"/></Vars>
+	<Nodes>
+		<ConditionNode name="authStart">
+			<Conditions><Condition expr="authAni == '13800138000'" nextNode="authOk"/></Conditions>
+			<Default>authFailed</Default>
+		</ConditionNode>
+		<ReturnNode name="authOk"><Value>ok</Value></ReturnNode>
+		<ReturnNode name="authFailed"><Value>failed</Value></ReturnNode>
+	</Nodes>
+</IVRConfig>`
+
+func TestSubFlowNode(t *testing.T) {
+
+	dir := t.TempDir()
+	main := filepath.Join(dir, "ivr.xml")
+	if err := ioutil.WriteFile(main, []byte(testMainFlow), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "flows"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "flows", "auth.xml"), []byte(testAuthFlow), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := ivr
+	ivr = NewIVR()
+	defer func() { ivr = saved }()
+
+	LoadIVRConfig(main)
+	for _, name := range []string{"checkCaller", "authStart", "authOk", "authFailed"} {
+		if _, ok := ivrNodeMap[name]; !ok {
+			t.Fatalf("Node %s not loaded", name)
+		}
+	}
+	if subFlow := ivrSubFlowMap["authenticate"]; subFlow.Root != "authStart" {
+		t.Fatalf("SubFlow root=%s,expect authStart", subFlow.Root)
+	}
+	if len(ivrVars) != 1 || ivrVars[0].Name != "authTries" {
+		t.Fatalf("Vars=%v,expect authTries of the sub flow file", ivrVars)
+	}
+	if len(ivrFlowFiles) != 1 {
+		t.Fatalf("FlowFiles=%v,expect the sub flow file", ivrFlowFiles)
+	}
+
+	ivrChannel := &IVRChannel{Vars: NewCallVars(), Log: calllog.New(), Record: NewCallRecord(time.Now())}
+	ivrChannel.Vars.Set("ANI", "13800138000")
+
+	next, err := ivrNodeMap["checkCaller"].Execute(ivrChannel)
+	if err != nil || next != "authStart" || len(ivrChannel.CallStack) != 1 {
+		t.Fatalf("Call next=%s,err=%v,depth=%d,expect authStart,1", next, err, len(ivrChannel.CallStack))
+	}
+	if ivrChannel.Vars.Get("authAni") != "13800138000" {
+		t.Fatalf("authAni=%s,expect 13800138000", ivrChannel.Vars.Get("authAni"))
+	}
+
+	next, _ = ivrNodeMap[next].Execute(ivrChannel)
+	next, err = ivrNodeMap[next].Execute(ivrChannel)
+	if err != nil || next != "mainMenu" || len(ivrChannel.CallStack) != 0 {
+		t.Fatalf("Return next=%s,err=%v,depth=%d,expect mainMenu,0", next, err, len(ivrChannel.CallStack))
+	}
+	if ivrChannel.Vars.Get("customer") != "vip-13800138000" || ivrChannel.Vars.Get("subflow_result") != "ok" {
+		t.Fatalf("customer=%s,subflow_result=%s", ivrChannel.Vars.Get("customer"), ivrChannel.Vars.Get("subflow_result"))
+	}
+
+	ivrChannel.Vars.Set("ANI", "13900139000")
+	next, _ = ivrNodeMap["checkCaller"].Execute(ivrChannel)
+	next, _ = ivrNodeMap[next].Execute(ivrChannel)
+	if next, _ = ivrNodeMap[next].Execute(ivrChannel); next != "toAgent" {
+		t.Fatalf("Return failed next=%s,expect toAgent", next)
+	}
+
+	if _, err = ivrNodeMap["authOk"].Execute(ivrChannel); err != noCallerErr {
+		t.Fatalf("Return without caller err=%v,expect %v", err, noCallerErr)
+	}
+
+	for i := 0; i < Max_SubFlow_Depth; i++ {
+		ivrNodeMap["checkCaller"].Execute(ivrChannel)
+	}
+	if _, err = ivrNodeMap["checkCaller"].Execute(ivrChannel); err == nil {
+		t.Fatalf("Call depth %d,expect overflow", len(ivrChannel.CallStack))
+	}
+
+	t.Log("Test pass.")
+}
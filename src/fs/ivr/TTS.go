@@ -0,0 +1,53 @@
+// fs/ivr/ TTS
+
+package ivr
+
+import (
+	"strings"
+)
+
+const Default_TTS_Engine string = "flite"
+const Default_TTS_Voice string = "kal"
+
+// TTSPhrase is a text prompt read by the speak app,eg.
+// <TTS engine="flite" voice="slt">Your balance is ${balance} dollars</TTS>
+// Engine and Voice default to the TTS of the server config.
+type TTSPhrase struct {
+	Engine string `xml:"engine,attr"`
+	Voice  string `xml:"voice,attr"`
+	Text   string `xml:",chardata"`
+}
+
+func (tts TTSPhrase) speakArg(vars *CallVars) string {
+	engine := tts.Engine
+	if engine == "" {
+		engine = serverConfig.TTS.Engine
+	}
+	if engine == "" {
+		engine = Default_TTS_Engine
+	}
+	voice := tts.Voice
+	if voice == "" {
+		voice = serverConfig.TTS.Voice
+	}
+	if voice == "" {
+		voice = Default_TTS_Voice
+	}
+	text := strings.Join(strings.Fields(vars.Expand(tts.Text)), " ")
+	return engine + "|" + voice + "|" + text
+}
+
+// speak reads tts to the caller and waits until it ends,it returns true when
+// the caller breaks it with one of playback_terminators.
+func (ivrChannel *IVRChannel) speak(tts TTSPhrase) (bool, error) {
+
+	arg := tts.speakArg(ivrChannel.Vars)
+	ivrChannel.Esocket.SetVar("playback_terminator_used", "")
+	event, err := ivrChannel.executeWait("speak", arg, 0)
+	if err != nil {
+		ivrChannel.Log.Warn("Speak failure for %s", err.Error())
+		return false, err
+	}
+	ivrChannel.channelVars(event, "playback_terminator_used")
+	return event.Header["variable_playback_terminator_used"] != "", nil
+}
@@ -0,0 +1,24 @@
+// TTS test
+
+package ivr
+
+import (
+	"testing"
+)
+
+func TestSpeakArg(t *testing.T) {
+
+	vars := NewCallVars()
+	vars.Set("balance", "1024.50")
+
+	tts := TTSPhrase{Text: "\n\t\t\tYour balance is ${balance} dollars${unknown}\n\t\t"}
+	if arg := tts.speakArg(vars); arg != "flite|kal|Your balance is 1024.50 dollars" {
+		t.Fatalf("Speak arg=%s", arg)
+	}
+
+	tts = TTSPhrase{Engine: "unimrcp", Voice: "Ting-Ting", Text: "${ balance }"}
+	if arg := tts.speakArg(vars); arg != "unimrcp|Ting-Ting|1024.50" {
+		t.Fatalf("Speak arg=%s", arg)
+	}
+	t.Log("Test pass.")
+}
@@ -0,0 +1,101 @@
+// fs/ivr/ TransferNode
+
+package ivr
+
+import (
+	"errors"
+	"fs/ivr/eventsocket"
+	"strings"
+)
+
+const Default_Transfer_Timeout int = 5000
+
+// TransferAttach copies a call variable to the channel for the agent's screen-pop,
+// as SIP header Header (sent as sip_h_<Header>) and/or as channel variable ChannelVar.
+type TransferAttach struct {
+	Var        string `xml:"var,attr"`
+	Header     string `xml:"header,attr"`
+	ChannelVar string `xml:"channelVar,attr"`
+}
+
+type TransferAttaches struct {
+	Attach []TransferAttach
+}
+
+// TransferNode blindly transfers the caller to an extension of a dialplan
+// context,or to a SIP URI through an inline bridge.The flow ends when the call
+// leaves the socket,OnFailure is executed when the transfer is refused or
+// the call is still here after Timeout.
+type TransferNode struct {
+	NodeName  string `xml:"name,attr"`
+	Prompts   PromptEntity
+	Extension string
+	Dialplan  string // Default XML.
+	Context   string
+	Uri       string // eg. sip:1000@172.16.0.188:5060
+	Profile   string // Sofia profile of Uri,default external.
+	Attaches  TransferAttaches
+	Timeout   int // Millisecond.
+	OnFailure string
+}
+
+func (node TransferNode) target(vars *CallVars) (string, string, string) {
+	if node.Uri != "" {
+		profile := node.Profile
+		if profile == "" {
+			profile = "external"
+		}
+		uri := strings.TrimPrefix(vars.Expand(node.Uri), "sip:")
+		return "'bridge:sofia/" + profile + "/" + uri + "'", "inline", ""
+	}
+	return vars.Expand(node.Extension), node.Dialplan, vars.Expand(node.Context)
+}
+
+func (node TransferNode) Execute(ivrChannel *IVRChannel) (string, error) {
+
+	if ivrChannel.ChannelState == IVRChannel_State_Hangup {
+		return "", errors.New("channel state is invalid : hangup")
+	}
+
+	ivrChannel.SetActiveNode(node.NodeName)
+
+	executePrompt(node.Prompts.Prompt, ivrChannel)
+
+	for _, attach := range node.Attaches.Attach {
+		value := ivrChannel.Vars.Get(attach.Var)
+		if attach.Header != "" {
+			ivrChannel.Esocket.SetVar("sip_h_"+attach.Header, value)
+		}
+		if attach.ChannelVar != "" {
+			ivrChannel.Esocket.SetVar(attach.ChannelVar, value)
+		}
+	}
+
+	dest, dialplan, context := node.target(ivrChannel.Vars)
+	ivrChannel.Log.Info("Transfer call to %s %s %s", dest, dialplan, context)
+	if err := ivrChannel.Esocket.Transfer(dest, dialplan, context); err != nil {
+		ivrChannel.Log.Warn("Transfer failure for %s", err.Error())
+		return node.OnFailure, nil
+	}
+
+	timeoutMs := node.Timeout
+	if timeoutMs <= 0 {
+		timeoutMs = Default_Transfer_Timeout
+	}
+
+	// The socket is closed by FreeSWITCH once the call leaves it.
+	timeout := eventsocket.CheckTimeout(timeoutMs)
+	select {
+	case <-timeout:
+		ivrChannel.Log.Warn("Call is still here after transfer,execute %s", node.OnFailure)
+		return node.OnFailure, nil
+	case <-ivrChannel.ChannelHangup:
+		if cause := ivrChannel.Record.Cause(); cause != "" {
+			ivrChannel.Log.Trace("Channel hangup while transferring,cause=%s", cause)
+			return "", errors.New("Channel hangup.")
+		}
+		ivrChannel.Record.SetOutcome(Outcome_Transferred)
+		ivrChannel.Log.Info("Call transferred to %s", dest)
+		return "", nil
+	}
+}
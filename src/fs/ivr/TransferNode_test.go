@@ -0,0 +1,37 @@
+// TransferNode test
+
+package ivr
+
+import (
+	"testing"
+)
+
+func TestTransferNode(t *testing.T) {
+
+	vars := NewCallVars()
+	vars.Set("agentGroup", "8001")
+	vars.Set("region", "north")
+	vars.Set("agentHost", "172.16.0.188")
+
+	tests := []struct {
+		node     TransferNode
+		dest     string
+		dialplan string
+		context  string
+	}{
+		{TransferNode{Extension: "${agentGroup}", Context: "default"}, "8001", "", "default"},
+		{TransferNode{Extension: "${agentGroup}", Dialplan: "XML", Context: "agents_${region}"}, "8001", "XML", "agents_north"},
+		{TransferNode{Extension: "9000"}, "9000", "", ""},
+		{TransferNode{Uri: "sip:1000@${agentHost}:5060"}, "'bridge:sofia/external/1000@172.16.0.188:5060'", "inline", ""},
+		{TransferNode{Uri: "1000@172.16.0.188", Profile: "internal", Extension: "9000", Context: "default"},
+			"'bridge:sofia/internal/1000@172.16.0.188'", "inline", ""},
+	}
+	for _, test := range tests {
+		dest, dialplan, context := test.node.target(vars)
+		if dest != test.dest || dialplan != test.dialplan || context != test.context {
+			t.Fatalf("target(%+v)=%s,%s,%s,expect %s,%s,%s", test.node, dest, dialplan, context, test.dest, test.dialplan, test.context)
+		}
+	}
+
+	t.Log("Test pass.")
+}
@@ -8,11 +8,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type IVRConfig struct {
 	Prompts  Prompts
 	Grammars Grammars
+	Queries  Queries
+	SubFlows SubFlows
+	Vars     Vars
 	Nodes    Nodes
 }
 
@@ -24,21 +29,6 @@ type Grammars struct {
 	Grammar []Grammar
 }
 
-type Nodes struct {
-	RootNode          RootNode
-	ExitNode          ExitNode
-	AnnNode           []AnnNode
-	MenuNode          []MenuNode
-	PromptCollectNode []PromptCollectNode
-	GotoNode          []GotoNode
-}
-
-type Prompt struct {
-	PName   string `xml:"name,attr"`
-	BargeIn bool
-	Phrase  []string
-}
-
 type PromptEntity struct {
 	Prompt []string
 }
@@ -47,6 +37,7 @@ type MenuChoice struct {
 	Name     string `xml:"name,attr"`
 	DTMF     string `xml:"dtmf,attr"`
 	NextNode string `xml:"nextNode,attr"`
+	Set      string `xml:"set,attr"` // Call variables set on match,eg. language=en;vip=true
 }
 
 type Choices struct {
@@ -59,6 +50,7 @@ type Grammar struct {
 	Terminator string
 	Timeout    int
 	Express    string
+	Sensitive  bool // Digits are masked in logs and records.
 }
 
 type GrammarEntity struct {
@@ -67,88 +59,150 @@ type GrammarEntity struct {
 
 func LoadIVRConfig(name string) {
 
-	fileInfo, err := os.Stat(name)
+	modTime, err := flowFilesModTime(name)
 	if err != nil {
 		l4g.Error("Load Ivr config file failure for : %s", err.Error())
 		return
 	}
 
-	l4g.Debug("ConfigFile modTime=%d,loadTime=%d", fileInfo.ModTime(), ivr.confFileLoadTime)
-	if fileInfo.ModTime().UnixNano() <= ivr.confFileLoadTime.UnixNano() {
+	l4g.Debug("ConfigFile modTime=%d,loadTime=%d", modTime, ivr.confFileLoadTime)
+	if modTime.UnixNano() <= ivr.confFileLoadTime.UnixNano() {
 		return
 	}
 
-	ivr.confFileLoadTime = fileInfo.ModTime()
+	ivr.confFileLoadTime = modTime
 
 	l4g.Trace("Init ivr config file from: %s", name)
-	content, err := ioutil.ReadFile(name)
+	ivrConfig, err := readIVRConfig(name)
 	if err != nil {
-		l4g.Error("Load Ivr config file[%s] failure for %s", name, err.Error())
-		return
-	}
-
-	// l4g.Debug("Load config content : %s", string(content))
-	var ivrConfig IVRConfig
-	err = xml.Unmarshal(content, &ivrConfig)
-	if err != nil {
-		l4g.Error("Unmarshal config xml failure for %s", err.Error())
 		return
 	}
 
 	// fmt.Println(ivr)
 
-	if len(ivrConfig.Prompts.Prompt) > 0 {
-		for _, prompt := range ivrConfig.Prompts.Prompt {
-			ivrPromptMap[prompt.PName] = prompt
-		}
-	} else {
+	if len(ivrConfig.Prompts.Prompt) == 0 {
 		l4g.Warn("Init IVR config no prompt find ...")
 	}
 
-	if len(ivrConfig.Grammars.Grammar) > 0 {
-		for _, grammar := range ivrConfig.Grammars.Grammar {
-			ivrGrammarMap[grammar.GName] = grammar
-		}
-	} else {
+	if len(ivrConfig.Grammars.Grammar) == 0 {
 		l4g.Warn("Init IVR config no grammar find ...")
 	}
 
-	if len(ivrConfig.Nodes.RootNode.NodeName) > 0 {
-		ivrNodeMap[ivrConfig.Nodes.RootNode.NodeName] = ivrConfig.Nodes.RootNode
-	} else {
+	if !ivrConfig.Nodes.Has("RootNode") {
 		fmt.Println("No RootNode find ------------------------------------------------ ")
 	}
 
-	if len(ivrConfig.Nodes.ExitNode.NodeName) > 0 {
-		ivrNodeMap[ivrConfig.Nodes.ExitNode.NodeName] = ivrConfig.Nodes.ExitNode
-	} else {
+	if !ivrConfig.Nodes.Has("ExitNode") {
 		fmt.Println("No ExitNode find ------------------------------------------------ ")
 	}
 
-	if len(ivrConfig.Nodes.GotoNode) > 0 {
-		for _, gotoNode := range ivrConfig.Nodes.GotoNode {
-			ivrNodeMap[gotoNode.NodeName] = gotoNode
+	nodeFiles := make(map[string]string)
+	checkNodes(name, ivrConfig.Nodes, nodeFiles)
+	addIVRConfig(ivrConfig)
+	ivrVars = ivrConfig.Vars.Var
+
+	// Sub flows of other files,their paths are relative to the flow file.
+	flowFiles := make([]string, 0)
+	for _, subFlow := range ivrConfig.SubFlows.SubFlow {
+		if subFlow.File == "" {
+			continue
+		}
+		file := subFlow.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(name), file)
 		}
+		flowFiles = append(flowFiles, file)
+		subConfig, err := readIVRConfig(file)
+		if err != nil {
+			continue
+		}
+		checkNodes(file, subConfig.Nodes, nodeFiles)
+		addIVRConfig(subConfig)
+		ivrVars = append(ivrVars, subConfig.Vars.Var...)
 	}
+	ivrFlowFiles = flowFiles
 
-	if len(ivrConfig.Nodes.AnnNode) > 0 {
-		for _, annNode := range ivrConfig.Nodes.AnnNode {
-			ivrNodeMap[annNode.NodeName] = annNode
-		}
+	l4g.Trace("Load ivrConfig prompts=%d,grammars=%d,subFlows=%d,nodes=%d", len(ivrPromptMap), len(ivrGrammarMap), len(ivrSubFlowMap), len(ivrNodeMap))
+
+}
+
+// flowFilesModTime returns the last modification of the flow file and of
+// the sub flow files it loaded.
+func flowFilesModTime(name string) (time.Time, error) {
+
+	fileInfo, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}, err
 	}
 
-	if len(ivrConfig.Nodes.MenuNode) > 0 {
-		for _, menuNode := range ivrConfig.Nodes.MenuNode {
-			ivrNodeMap[menuNode.NodeName] = menuNode
+	modTime := fileInfo.ModTime()
+	for _, file := range ivrFlowFiles {
+		if fileInfo, err := os.Stat(file); err == nil && fileInfo.ModTime().After(modTime) {
+			modTime = fileInfo.ModTime()
 		}
 	}
+	return modTime, nil
+}
+
+// checkNodes warns about the unknown elements of flow file name and about the
+// nodes named like a node before,nodeFiles maps the names to their files.
+func checkNodes(name string, nodes Nodes, nodeFiles map[string]string) {
+
+	for _, element := range nodes.unknown {
+		l4g.Warn("Unknown node type %s in %s,skip it", element, name)
+	}
 
-	if len(ivrConfig.Nodes.PromptCollectNode) > 0 {
-		for _, pcNode := range ivrConfig.Nodes.PromptCollectNode {
-			ivrNodeMap[pcNode.NodeName] = pcNode
+	for _, node := range nodes.Node {
+		if file, ok := nodeFiles[node.Name]; ok {
+			l4g.Warn("Duplicate node %s in %s replaces the one in %s", node.Name, name, file)
 		}
+		nodeFiles[node.Name] = name
 	}
+}
 
-	l4g.Trace("Load ivrConfig prompts=%d,grammars=%d,nodes=%d", len(ivrPromptMap), len(ivrGrammarMap), len(ivrNodeMap))
+func readIVRConfig(name string) (*IVRConfig, error) {
 
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		l4g.Error("Load Ivr config file[%s] failure for %s", name, err.Error())
+		return nil, err
+	}
+
+	// l4g.Debug("Load config content : %s", string(content))
+	ivrConfig := new(IVRConfig)
+	err = xml.Unmarshal(content, ivrConfig)
+	if err != nil {
+		l4g.Error("Unmarshal config xml[%s] failure for %s", name, err.Error())
+		return nil, err
+	}
+	if err = loadNodes(name, &ivrConfig.Nodes); err != nil {
+		l4g.Error("Load nodes of config xml[%s] failure for %s", name, err.Error())
+		return nil, err
+	}
+	return ivrConfig, nil
+}
+
+// addIVRConfig adds the prompts,grammars,queries,sub flows and nodes of a
+// flow file,names of the files share one space.
+func addIVRConfig(ivrConfig *IVRConfig) {
+
+	for _, prompt := range ivrConfig.Prompts.Prompt {
+		ivrPromptMap[prompt.PName] = prompt
+	}
+
+	for _, grammar := range ivrConfig.Grammars.Grammar {
+		ivrGrammarMap[grammar.GName] = grammar
+	}
+
+	for _, query := range ivrConfig.Queries.Query {
+		ivrQueryMap[query.QName] = query
+	}
+
+	for _, subFlow := range ivrConfig.SubFlows.SubFlow {
+		ivrSubFlowMap[subFlow.Name] = subFlow
+	}
+
+	for _, node := range ivrConfig.Nodes.Node {
+		ivrNodeMap[node.Name] = node.Node
+	}
 }
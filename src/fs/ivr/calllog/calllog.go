@@ -0,0 +1,316 @@
+// fs/ivr/calllog
+
+/*
+*	Per call structured logger,every line is written to log4go in logfmt
+*	with the call identifiers : level=debug call=... ani=... dnis=... node=... msg="..."
+ */
+
+package calllog
+
+import (
+	l4g "code.google.com/p/log4go"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Level int
+
+const (
+	FINEST Level = iota
+	FINE
+	DEBUG
+	TRACE
+	INFO
+	WARNING
+	ERROR
+	CRITICAL
+)
+
+var levelNames = []string{"finest", "fine", "debug", "trace", "info", "warning", "error", "critical"}
+
+const Field_Call string = "call"
+const Field_ANI string = "ani"
+const Field_DNIS string = "dnis"
+const Field_Node string = "node"
+
+// Mask_Value replaces sensitive values such as passwords in log lines and records.
+const Mask_Value string = "******"
+
+// Mask_Min_Len is the shortest secret masked inside a longer text.
+const Mask_Min_Len int = 4
+
+// Field order of every line,other fields follow in the order they are set.
+var fixedFields = []string{Field_Call, Field_ANI, Field_DNIS, Field_Node}
+
+func (level Level) String() string {
+	if level >= FINEST && level <= CRITICAL {
+		return levelNames[level]
+	}
+	return "unknown"
+}
+
+func ParseLevel(name string) (Level, bool) {
+	name = strings.ToLower(name)
+	if name == "warn" {
+		return WARNING, true
+	}
+	for i, levelName := range levelNames {
+		if levelName == name {
+			return Level(i), true
+		}
+	}
+	return INFO, false
+}
+
+// Overrides registered by call uuid or ANI,see SetOverride.
+var overrideMap map[string]Level = make(map[string]Level)
+var overrideMutex sync.RWMutex
+
+// SetOverride makes every call whose uuid or ANI equals key log from level on,
+// whatever the level of the log4go filters is.
+func SetOverride(key string, level Level) {
+	overrideMutex.Lock()
+	defer overrideMutex.Unlock()
+	overrideMap[key] = level
+}
+
+func ClearOverride(key string) {
+	overrideMutex.Lock()
+	defer overrideMutex.Unlock()
+	delete(overrideMap, key)
+}
+
+func Overrides() map[string]Level {
+	overrideMutex.RLock()
+	defer overrideMutex.RUnlock()
+	overrides := make(map[string]Level)
+	for k, v := range overrideMap {
+		overrides[k] = v
+	}
+	return overrides
+}
+
+type Logger struct {
+	mutex  sync.RWMutex
+	fields map[string]string
+	extra  []string // Names of non fixed fields.
+}
+
+func New() *Logger {
+	logger := new(Logger)
+	logger.fields = make(map[string]string)
+	return logger
+}
+
+// Set adds or updates a field printed on every line.
+func (logger *Logger) Set(key, value string) {
+	if logger == nil {
+		return
+	}
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if _, ok := logger.fields[key]; !ok && !isFixedField(key) {
+		logger.extra = append(logger.extra, key)
+	}
+	logger.fields[key] = value
+}
+
+func (logger *Logger) Get(key string) string {
+	if logger == nil {
+		return ""
+	}
+	logger.mutex.RLock()
+	defer logger.mutex.RUnlock()
+	return logger.fields[key]
+}
+
+func (logger *Logger) Finest(format string, args ...interface{}) {
+	logger.log(FINEST, format, args...)
+}
+
+func (logger *Logger) Fine(format string, args ...interface{}) {
+	logger.log(FINE, format, args...)
+}
+
+func (logger *Logger) Debug(format string, args ...interface{}) {
+	logger.log(DEBUG, format, args...)
+}
+
+func (logger *Logger) Trace(format string, args ...interface{}) {
+	logger.log(TRACE, format, args...)
+}
+
+func (logger *Logger) Info(format string, args ...interface{}) {
+	logger.log(INFO, format, args...)
+}
+
+func (logger *Logger) Warn(format string, args ...interface{}) {
+	logger.log(WARNING, format, args...)
+}
+
+func (logger *Logger) Error(format string, args ...interface{}) {
+	logger.log(ERROR, format, args...)
+}
+
+// Format returns the logfmt line of message at level.
+func (logger *Logger) Format(level Level, message string) string {
+
+	buf := make([]string, 0, len(fixedFields)+4)
+	buf = append(buf, "level="+level.String())
+
+	if logger != nil {
+		logger.mutex.RLock()
+		for _, key := range fixedFields {
+			if value, ok := logger.fields[key]; ok {
+				buf = append(buf, key+"="+quote(value))
+			}
+		}
+		for _, key := range logger.extra {
+			buf = append(buf, key+"="+quote(logger.fields[key]))
+		}
+		logger.mutex.RUnlock()
+	}
+
+	buf = append(buf, "msg="+quote(message))
+	return strings.Join(buf, " ")
+}
+
+func (logger *Logger) log(level Level, format string, args ...interface{}) {
+
+	// An overridden call is written at INFO at least so that it passes
+	// the production filters,the real level stays in the level field.
+	outLevel := level
+	if override, ok := logger.override(); ok {
+		if level < override {
+			return
+		}
+		if outLevel < INFO {
+			outLevel = INFO
+		}
+	}
+
+	source := ""
+	if pc, _, line, ok := runtime.Caller(2); ok {
+		source = fmt.Sprintf("%s:%d", runtime.FuncForPC(pc).Name(), line)
+	}
+
+	message := format
+	if len(args) > 0 {
+		message = fmt.Sprintf(format, args...)
+	}
+	line := logger.Format(level, message)
+
+	switch outLevel {
+	case FINEST:
+		l4g.Log(l4g.FINEST, source, line)
+	case FINE:
+		l4g.Log(l4g.FINE, source, line)
+	case DEBUG:
+		l4g.Log(l4g.DEBUG, source, line)
+	case TRACE:
+		l4g.Log(l4g.TRACE, source, line)
+	case INFO:
+		l4g.Log(l4g.INFO, source, line)
+	case WARNING:
+		l4g.Log(l4g.WARNING, source, line)
+	case ERROR:
+		l4g.Log(l4g.ERROR, source, line)
+	default:
+		l4g.Log(l4g.CRITICAL, source, line)
+	}
+}
+
+func (logger *Logger) override() (Level, bool) {
+
+	if logger == nil {
+		return INFO, false
+	}
+
+	overrideMutex.RLock()
+	defer overrideMutex.RUnlock()
+	if len(overrideMap) == 0 {
+		return INFO, false
+	}
+
+	for _, key := range []string{Field_Call, Field_ANI} {
+		if value := logger.Get(key); value != "" {
+			if level, ok := overrideMap[value]; ok {
+				return level, true
+			}
+		}
+	}
+	return INFO, false
+}
+
+// Mask returns Mask_Value for a non empty value.
+func Mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	return Mask_Value
+}
+
+// MaskAll replaces the secrets found in text by Mask_Value.A secret is masked
+// where it stands alone,not inside a longer number or word such as a uuid or
+// a caller number,and a secret shorter than Mask_Min_Len only when it is the
+// whole text,eg. a digit or a call variable.
+func MaskAll(text string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		if text == secret {
+			return Mask_Value
+		}
+		if len(secret) >= Mask_Min_Len {
+			text = maskWord(text, secret)
+		}
+	}
+	return text
+}
+
+// maskWord masks secret in text where it is not part of a longer word.
+func maskWord(text, secret string) string {
+	var buf strings.Builder
+	for {
+		i := strings.Index(text, secret)
+		if i < 0 {
+			buf.WriteString(text)
+			return buf.String()
+		}
+		end := i + len(secret)
+		if (i == 0 || !isWordByte(text[i-1])) && (end == len(text) || !isWordByte(text[end])) {
+			buf.WriteString(text[:i])
+			buf.WriteString(Mask_Value)
+		} else {
+			buf.WriteString(text[:end])
+		}
+		text = text[end:]
+	}
+}
+
+func isWordByte(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || b == '_'
+}
+
+func isFixedField(key string) bool {
+	for _, field := range fixedFields {
+		if field == key {
+			return true
+		}
+	}
+	return false
+}
+
+func quote(value string) string {
+	if value == "" {
+		return `""`
+	}
+	if strings.ContainsAny(value, " =\"\t\r\n\\") {
+		return strconv.Quote(value)
+	}
+	return value
+}
@@ -0,0 +1,43 @@
+// calllog test
+
+package calllog
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+
+	logger := New()
+	logger.Set(Field_Node, "languageMenu")
+	logger.Set("flow", "bank")
+	logger.Set(Field_Call, "6f1c")
+	logger.Set(Field_ANI, "1001")
+
+	line := logger.Format(WARNING, "Timeout,no dtmf.")
+	expect := `level=warning call=6f1c ani=1001 node=languageMenu flow=bank msg="Timeout,no dtmf."`
+	if line != expect {
+		t.Fatalf("Format line=%s,expect=%s", line, expect)
+	}
+
+	if level, ok := ParseLevel("DEBUG"); !ok || level != DEBUG {
+		t.Fatalf("ParseLevel DEBUG=%s", level)
+	}
+	tests := []struct {
+		text    string
+		secrets []string
+		masked  string
+	}{
+		{"execute-app-arg: pwd=147258", []string{"147258"}, "execute-app-arg: pwd=" + Mask_Value},
+		{"pin=1234 uuid=9f1234ab-1234e ani=13812345678", []string{"1234"}, "pin=" + Mask_Value + " uuid=9f1234ab-1234e ani=13812345678"},
+		{"1234", []string{"1234"}, Mask_Value},
+		{"5", []string{"5"}, Mask_Value},
+		{"seq=5 time=12:05", []string{"5"}, "seq=5 time=12:05"},
+	}
+	for _, test := range tests {
+		if masked := MaskAll(test.text, test.secrets); masked != test.masked {
+			t.Fatalf("MaskAll(%s,%v)=%s,expect %s", test.text, test.secrets, masked, test.masked)
+		}
+	}
+	t.Log("Test pass.")
+}
@@ -10,16 +10,18 @@ package eventsocket
 import (
 	"bufio"
 	"bytes"
-	l4g "code.google.com/p/log4go"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"fs/ivr/calllog"
 	"io"
 	"net"
 	"net/textproto"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const readerBufSize int = 1024 << 6
@@ -64,6 +66,9 @@ type ESocket struct {
 	cmd        chan *Event
 	Running    bool
 	Dispatcher EventDispatcher
+	Log        *calllog.Logger
+	secrets    []string
+	secretLock sync.RWMutex
 }
 
 type EventHeader map[string]string // key:value.
@@ -87,10 +92,28 @@ func NewESocket(conn net.Conn, dispatcher EventDispatcher) *ESocket {
 	esocket.err = make(chan error)
 	esocket.cmd = make(chan *Event)
 	esocket.Dispatcher = dispatcher
+	esocket.Log = calllog.New()
 	esocket.Running = true
 	return esocket
 }
 
+// AddSecret registers a sensitive value which is masked in all trace output.
+func (es *ESocket) AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	es.secretLock.Lock()
+	defer es.secretLock.Unlock()
+	es.secrets = append(es.secrets, secret)
+}
+
+// Mask masks the secrets of the call in text.
+func (es *ESocket) Mask(text string) string {
+	es.secretLock.RLock()
+	defer es.secretLock.RUnlock()
+	return calllog.MaskAll(text, es.secrets)
+}
+
 func (es *ESocket) Init() {
 	go es.RecLoop()
 }
@@ -99,10 +122,10 @@ func (es *ESocket) AnswerCall() (string, error) {
 	req := newESRequest("execute", "answer")
 	res, err := es.handleESRequest(req)
 	if err != nil {
-		l4g.Warn("AnswerCall failure for : %s", err.Error())
+		es.Log.Warn("AnswerCall failure for : %s", err.Error())
 		return "", err
 	}
-	l4g.Trace("AnswerCall ok.")
+	es.Log.Trace("AnswerCall ok.")
 	return res, nil
 }
 
@@ -128,6 +151,35 @@ func (es *ESocket) PlayAnn(annfile, param1, param2 string) error {
 	return err
 }
 
+// Execute runs a dialplan application on the channel.
+func (es *ESocket) Execute(app, arg string) (string, error) {
+	req := newESRequest("execute", app)
+	req.Req_Arg = arg
+	return es.handleESRequest(req)
+}
+
+// SetVar sets a channel variable.
+func (es *ESocket) SetVar(name, value string) error {
+	_, err := es.Execute("set", name+"="+value)
+	return err
+}
+
+// Export sets a channel variable which is copied to the bridged channel too.
+func (es *ESocket) Export(name, value string) error {
+	_, err := es.Execute("export", name+"="+value)
+	return err
+}
+
+// Transfer blindly transfers the channel to dest in dialplan/context.
+func (es *ESocket) Transfer(dest, dialplan, context string) error {
+	if context != "" && dialplan == "" {
+		dialplan = "XML"
+	}
+	arg := strings.TrimSpace(dest + " " + dialplan + " " + context)
+	_, err := es.Execute("transfer", arg)
+	return err
+}
+
 func (es *ESocket) BargeIn(barge_in bool) error {
 	req := newESRequest("execute", "set")
 	if barge_in {
@@ -155,29 +207,76 @@ func (es *ESocket) StopDTMF() error {
 
 func (es *ESocket) SendCmd(cmd string) (string, error) {
 
+	res, err := es.sendCmd(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	if len(res.Header["Channel-Unique-Id"]) > 0 {
+		return res.Header["Channel-Unique-Id"], nil
+	}
+	return res.Header["Reply-Text"], nil
+}
+
+// Api runs api command cmd of FreeSWITCH,eg. uuid_getvar,and returns its
+// response.
+func (es *ESocket) Api(cmd string) (string, error) {
+
+	if !es.Running {
+		return "", errors.New("Conn already closed")
+	}
+
+	es.Log.Debug("Send api --> %s", es.Mask(cmd))
+	fmt.Fprintf(es.conn, "api %s\n\n", strings.TrimSpace(cmd))
+
+	timeout := CheckTimeout(requestTimeout)
+	select {
+	case <-timeout:
+		return "", errors.New("Timeout : " + cmd)
+	case res := <-es.cmd:
+		body := strings.TrimSpace(res.Body)
+		if strings.HasPrefix(body, "-ERR") {
+			return "", errors.New(body)
+		}
+		return body, nil
+	case err := <-es.err:
+		return "", err
+	}
+}
+
+// Connect sends connect command of outbound mode and returns the channel data.
+func (es *ESocket) Connect() (*Event, error) {
+	return es.sendCmd("connect")
+}
+
+// Exit asks FreeSWITCH to close the socket,the call will go on with next dialplan action.
+func (es *ESocket) Exit() error {
+	_, err := es.sendCmd("exit")
+	return err
+}
+
+func (es *ESocket) sendCmd(cmd string) (*Event, error) {
+
 	if es.Running {
-		l4g.Debug("Send cmd --> %s", cmd)
-		fmt.Fprintf(es.conn, "%s\n\n", cmd)
+		es.Log.Debug("Send cmd --> %s", es.Mask(cmd))
+		fmt.Fprintf(es.conn, "%s\n\n", strings.TrimSpace(cmd))
 
 		timeout := CheckTimeout(requestTimeout)
 		select {
 		case <-timeout:
-			return "", errors.New("Timeout : " + cmd)
+			return nil, errors.New("Timeout : " + cmd)
 		case res := <-es.cmd:
-			l4g.Trace("Request res : %s--%s", res.Header["Reply-Text"], res.Header["Channel-Unique-Id"])
+			es.Log.Trace("Request res : %s--%s", res.Header["Reply-Text"], res.Header["Channel-Unique-Id"])
 			if strings.Contains(res.Header["Reply-Text"], "OK") {
-				if len(res.Header["Channel-Unique-Id"]) > 0 {
-					return res.Header["Channel-Unique-Id"], nil
-				}
-				return res.Header["Reply-Text"], nil
+				return res, nil
 			} else {
-				return "", errors.New(res.Header["Reply-Text"])
+				return nil, errors.New(res.Header["Reply-Text"])
 			}
 		case err := <-es.err:
-			return "", err
+			return nil, err
 		}
 	} else {
-		return "", errors.New("Conn already closed")
+		return nil, errors.New("Conn already closed")
 	}
 
 }
@@ -193,7 +292,7 @@ func (es *ESocket) handleESRequest(request *ESRequest) (string, error) {
 			buf.WriteString("execute-app-arg: " + request.Req_Arg + "\n")
 		}
 		buf.WriteString("event-lock: true\n")
-		l4g.Trace("SendRequest ---> : \n{%s}\n", buf.String())
+		es.Log.Trace("SendRequest ---> : %s", es.Mask(strings.Replace(strings.TrimSpace(buf.String()), "\n", " | ", -1)))
 		fmt.Fprintf(es.conn, "%s\n", buf.String())
 
 		timeout := CheckTimeout(requestTimeout)
@@ -201,7 +300,7 @@ func (es *ESocket) handleESRequest(request *ESRequest) (string, error) {
 		case <-timeout:
 			return "", errors.New("Timeout : " + request.Req_App)
 		case res := <-es.cmd:
-			l4g.Trace("Request res : %s", res.Header["Reply-Text"])
+			es.Log.Trace("Request res : %s", res.Header["Reply-Text"])
 			return res.Header["Reply-Text"], nil
 		case err := <-es.err:
 			return "", err
@@ -239,11 +338,23 @@ func praseHeader(msg textproto.MIMEHeader, event *Event, decode bool) {
 
 }
 
+// logHeaders writes the event headers one per line sorted by name.
+func (es *ESocket) logHeaders(event *Event) {
+	names := make([]string, 0, len(event.Header))
+	for name := range event.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		es.Log.Finest("Header %s=%s", name, es.Mask(event.Header[name]))
+	}
+}
+
 func (es *ESocket) recEvent() bool {
 
 	msg, err := es.textReader.ReadMIMEHeader()
 	if err != nil {
-		fmt.Println("Error:Read header failure for", err.Error())
+		es.Log.Warn("Read header failure for %s", err.Error())
 		return false
 	}
 	// l4g.Debug(">>>>> %s", msg)
@@ -252,12 +363,12 @@ func (es *ESocket) recEvent() bool {
 	if content := msg.Get(Header_Content_Len); content != "" {
 		len, err := strconv.Atoi(content)
 		if err != nil {
-			fmt.Println(err.Error())
+			es.Log.Warn("Invalid content length %s", content)
 			return false
 		}
 		b_body := make([]byte, len)
 		if _, err := io.ReadFull(es.reader, b_body); err != nil {
-			fmt.Println("Error:Read content failure for", err.Error())
+			es.Log.Warn("Read content failure for %s", err.Error())
 			return false
 		}
 		event.Body = string(b_body)
@@ -271,7 +382,13 @@ func (es *ESocket) recEvent() bool {
 			return true
 		}
 		praseHeader(msg, event, true)
-		l4g.Debug("Get cmd response : %s", event.Header)
+		es.Log.Debug("Get cmd response : %s", event.Header[Header_Reply_Text])
+		es.logHeaders(event)
+		es.cmd <- event
+
+	case Header_Api_Response:
+		praseHeader(msg, event, true)
+		es.Log.Debug("Get api response : %s", es.Mask(event.Body))
 		es.cmd <- event
 
 	case Header_Text_Json:
@@ -279,7 +396,7 @@ func (es *ESocket) recEvent() bool {
 		tmpBody := make(map[string]interface{})
 		err := json.Unmarshal([]byte(event.Body), &tmpBody)
 		if err != nil {
-			fmt.Println("Unmarshal json text failure for", err.Error())
+			es.Log.Error("Unmarshal json text failure for %s", err.Error())
 			es.err <- err
 			return false
 		}
@@ -302,13 +419,13 @@ func (es *ESocket) recEvent() bool {
 		// es.event <- event
 
 	case Header_Text_Disconn:
-		l4g.Debug("Disconnect-notice rec ... ")
+		es.Log.Debug("Disconnect-notice rec ... ")
 		event.Header["Event-Name"] = "HANGUP"
 		event.Header["Channel-Call-UUID"] = event.Header["Controlled-Session-Uuid"]
 		es.Dispatcher.OnEvent(event)
 		return false
 	default:
-		l4g.Warn("Unsupported event : %s", msg)
+		es.Log.Warn("Unsupported event : %s", msg)
 	}
 
 	return true
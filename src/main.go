@@ -3,19 +3,97 @@ package main
 
 import (
 	l4g "code.google.com/p/log4go"
+	"flag"
 	"fmt"
 	"fs/ivr"
+	"os"
 	"regexp"
+	"time"
 )
 
 func main() {
 
+	configFile := flag.String("config", ivr.Server_Config_File, "server config file")
+	flag.Usage = usage
+	flag.Parse()
+
 	l4g.LoadConfiguration("log4g.xml")
+	defer l4g.Close()
+
+	ivr.LoadServerConfig(*configFile)
 
-	ivr.InitIVRServer(8084)
+	switch flag.Arg(0) {
+	case "", "serve":
+		ivr.InitIVRServer(8084)
+	case "migrate":
+		migrate()
+	case "report":
+		report(flag.Args()[1:])
+	default:
+		usage()
+	}
 	// ivr.InitDB("tcp(172.168.2.107:3306)", "root", "root01", "ivr")
 }
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage : %s [-config server.xml] [serve|migrate|report]\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "  serve    run the IVR server (default)")
+	fmt.Fprintln(os.Stderr, "  migrate  create or upgrade the call record schema")
+	fmt.Fprintln(os.Stderr, "  report   print call reports, report -h for options")
+	flag.PrintDefaults()
+}
+
+func migrate() {
+	version, err := ivr.Migrate()
+	if err != nil {
+		fmt.Println("Migrate schema failure for :", err.Error())
+		l4g.Close()
+		os.Exit(1)
+	}
+	fmt.Println("Schema version :", version)
+}
+
+func report(args []string) {
+
+	reportFlags := flag.NewFlagSet("report", flag.ExitOnError)
+	today := time.Now().Format("2006-01-02")
+	fromDate := reportFlags.String("from", today, "first day, yyyy-mm-dd")
+	toDate := reportFlags.String("to", today, "last day, yyyy-mm-dd")
+	flow := reportFlags.String("flow", "", "flow name, all flows when empty")
+	topN := reportFlags.Int("top", 10, "number of paths and NoMatch inputs per node")
+	format := reportFlags.String("format", ivr.Report_Format_Text, "text, json or csv")
+	outFile := reportFlags.String("out", "", "output file, stdout when empty")
+	reportFlags.Parse(args)
+
+	from, err := time.ParseInLocation("2006-01-02", *fromDate, time.Local)
+	if err != nil {
+		reportExit(err)
+	}
+	to, err := time.ParseInLocation("2006-01-02", *toDate, time.Local)
+	if err != nil {
+		reportExit(err)
+	}
+
+	out := os.Stdout
+	if *outFile != "" {
+		if out, err = os.Create(*outFile); err != nil {
+			reportExit(err)
+		}
+		defer out.Close()
+	}
+
+	err = ivr.WriteReport(out, from, to.AddDate(0, 0, 1), *flow, *topN, *format)
+	if err != nil {
+		reportExit(err)
+	}
+}
+
+func reportExit(err error) {
+	fmt.Println("Report failure for :", err.Error())
+	l4g.Close()
+	os.Exit(1)
+}
+
 func exprDemo() {
 
 	value := "147258"